@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// albumDelay is how long to wait after the last photo of a media group
+// before treating the album as complete. Telegram delivers album photos as
+// separate updates within a few hundred milliseconds of each other.
+const albumDelay = 1500 * time.Millisecond
+
+type albumPhoto struct {
+	messageID int
+	fileID    string
+}
+
+type album struct {
+	chatID int64
+	photos []albumPhoto
+	timer  *time.Timer
+}
+
+// AlbumCollector groups photos sharing a MediaGroupID and hands them over in
+// message order once no new photo has arrived for the configured delay.
+type AlbumCollector struct {
+	mu     sync.Mutex
+	albums map[string]*album
+	delay  time.Duration
+	flush  func(chatID int64, fileIDs []string)
+}
+
+// NewAlbumCollector returns a collector that calls flush for every album.
+func NewAlbumCollector(delay time.Duration, flush func(chatID int64, fileIDs []string)) *AlbumCollector {
+	return &AlbumCollector{
+		albums: make(map[string]*album),
+		delay:  delay,
+		flush:  flush,
+	}
+}
+
+// Add records a photo of the media group and postpones the flush.
+func (c *AlbumCollector) Add(groupID string, chatID int64, messageID int, fileID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.albums[groupID]
+	if !ok {
+		a = &album{chatID: chatID}
+		a.timer = time.AfterFunc(c.delay, func() { c.complete(groupID) })
+		c.albums[groupID] = a
+	} else {
+		a.timer.Reset(c.delay)
+	}
+	a.photos = append(a.photos, albumPhoto{messageID: messageID, fileID: fileID})
+}
+
+func (c *AlbumCollector) complete(groupID string) {
+	c.mu.Lock()
+	a, ok := c.albums[groupID]
+	delete(c.albums, groupID)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	sort.Slice(a.photos, func(i, j int) bool { return a.photos[i].messageID < a.photos[j].messageID })
+	fileIDs := make([]string, len(a.photos))
+	for i, p := range a.photos {
+		fileIDs[i] = p.fileID
+	}
+	c.flush(a.chatID, fileIDs)
+}
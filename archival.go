@@ -0,0 +1,367 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Archival exports: hOCR, ALTO and PAGE XML keep the OCR geometry and
+// confidences, while the text content is the corrected text aligned back to
+// the OCR words. Where the corrector changed a word, ALTO and PAGE XML also
+// keep the original OCR reading.
+
+const (
+	altoNamespace = "http://www.loc.gov/standards/alto/ns-v4#"
+	altoSchema    = "http://www.loc.gov/standards/alto/v4/alto-4-4.xsd"
+	pageNamespace = "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15"
+	pageSchema    = "http://schema.primaresearch.org/PAGE/gts/pagecontent/2019-07-15/pagecontent.xsd"
+
+	// exportCreator names the producing software in the exported files.
+	exportCreator = "tgbogopd"
+)
+
+// xmlText escapes text for XML content and attribute values.
+func xmlText(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// pageImageName is the file name the exports use for the source image of
+// the n-th page.
+func pageImageName(n int, result *Result) string {
+	ext := ".jpg"
+	if result != nil && result.Source.MimeType == "image/png" {
+		ext = ".png"
+	}
+	return fmt.Sprintf("page-%d%s", n, ext)
+}
+
+// layoutBox returns the box of an element, computing it from the polygon
+// when the engine gave only that.
+func layoutBox(box BoundingBox, polygon []Point) BoundingBox {
+	if box.Empty() && len(polygon) > 0 {
+		return polygonBox(polygon)
+	}
+	return box
+}
+
+// buildHOCR renders the results as an hOCR document with one ocr_page per
+// source page.
+func buildHOCR(results []*Result, errs []error) []byte {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<!DOCTYPE html>` + "\n")
+	sb.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml">` + "\n<head>\n<title></title>\n")
+	sb.WriteString(`<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>` + "\n")
+	sb.WriteString(`<meta name="ocr-system" content="` + exportCreator + `"/>` + "\n")
+	sb.WriteString(`<meta name="ocr-capabilities" content="ocr_page ocr_carea ocr_par ocr_line ocrx_word"/>` + "\n")
+	sb.WriteString("</head>\n<body>\n")
+	for i, result := range results {
+		n := i + 1
+		if errs[i] != nil || result.OCR == nil {
+			fmt.Fprintf(&sb, `<div class="ocr_page" id="page_%d" title="ppageno %d"></div>`+"\n", n, i)
+			continue
+		}
+		ocr := result.OCR
+		aligned := alignCorrection(result)
+		fmt.Fprintf(&sb, `<div class="ocr_page" id="page_%d" title="image %s; bbox 0 0 %d %d; ppageno %d">`+"\n",
+			n, html.EscapeString(pageImageName(n, result)), ocr.Width, ocr.Height, i)
+		for b := range ocr.Blocks {
+			block := &ocr.Blocks[b]
+			id := fmt.Sprintf("%d_%d", n, b+1)
+			lang := ""
+			if len(block.Languages) > 0 {
+				lang = ` lang="` + html.EscapeString(block.Languages[0].Code) + `"`
+			}
+			title := hocrBBox(layoutBox(block.Box, block.Polygon))
+			fmt.Fprintf(&sb, `<div class="ocr_carea" id="block_%s" title="%s">`+"\n", id, title)
+			fmt.Fprintf(&sb, `<p class="ocr_par" id="par_%s"%s title="%s">`+"\n", id, lang, title)
+			for l := range block.Lines {
+				line := &block.Lines[l]
+				lineID := fmt.Sprintf("%s_%d", id, l+1)
+				fmt.Fprintf(&sb, `<span class="ocr_line" id="line_%s" title="%s">`, lineID, hocrBBox(layoutBox(line.Box, line.Polygon)))
+				written := false
+				for w := range line.Words {
+					word := &line.Words[w]
+					content := aligned[word]
+					// Слово, которое корректор удалил, в выгрузку не попадает
+					if content == "" {
+						continue
+					}
+					title := hocrBBox(layoutBox(word.Box, word.Polygon))
+					if word.Confidence >= 0 {
+						title += fmt.Sprintf("; x_wconf %.0f", word.Confidence*100)
+					}
+					if written {
+						sb.WriteString(" ")
+					}
+					written = true
+					fmt.Fprintf(&sb, `<span class="ocrx_word" id="word_%s_%d" title="%s">%s</span>`,
+						lineID, w+1, title, html.EscapeString(content))
+				}
+				sb.WriteString("</span>\n")
+			}
+			sb.WriteString("</p>\n</div>\n")
+		}
+		sb.WriteString("</div>\n")
+	}
+	sb.WriteString("</body>\n</html>\n")
+	return []byte(sb.String())
+}
+
+func hocrBBox(box BoundingBox) string {
+	return fmt.Sprintf("bbox %d %d %d %d", box.X, box.Y, box.X+box.Width, box.Y+box.Height)
+}
+
+// buildALTO renders the results as an ALTO v4 document with one Page per
+// source page. Changed words carry the OCR reading as an ALTERNATIVE.
+func buildALTO(results []*Result, errs []error) []byte {
+	now := time.Now().Format(time.RFC3339)
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<alto xmlns="` + altoNamespace + `" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ` +
+		`xsi:schemaLocation="` + altoNamespace + ` ` + altoSchema + `">` + "\n")
+	sb.WriteString("<Description>\n<MeasurementUnit>pixel</MeasurementUnit>\n<sourceImageInformation>\n")
+	for i, result := range results {
+		if errs[i] == nil {
+			sb.WriteString("<fileName>" + xmlText(pageImageName(i+1, result)) + "</fileName>\n")
+			break
+		}
+	}
+	sb.WriteString("</sourceImageInformation>\n")
+	for i, result := range results {
+		if errs[i] != nil || result.OCR == nil {
+			continue
+		}
+		settings := "engine=" + result.OCR.Engine + "; model=" + result.Profile.OCRModel
+		if result.Profile.Correct {
+			settings += "; correction=" + result.Profile.LLMModel
+		}
+		sb.WriteString(`<Processing ID="processing_1">` + "\n")
+		sb.WriteString("<processingDateTime>" + now + "</processingDateTime>\n")
+		sb.WriteString("<processingStepSettings>" + xmlText(settings) + "</processingStepSettings>\n")
+		sb.WriteString("<processingSoftware><softwareName>" + exportCreator + "</softwareName></processingSoftware>\n")
+		sb.WriteString("</Processing>\n")
+		break
+	}
+	sb.WriteString("</Description>\n<Layout>\n")
+	for i, result := range results {
+		n := i + 1
+		if errs[i] != nil || result.OCR == nil {
+			fmt.Fprintf(&sb, `<Page ID="page_%d" PHYSICAL_IMG_NR="%d"/>`+"\n", n, n)
+			continue
+		}
+		ocr := result.OCR
+		aligned := alignCorrection(result)
+		fmt.Fprintf(&sb, `<Page ID="page_%d" PHYSICAL_IMG_NR="%d" WIDTH="%d" HEIGHT="%d">`+"\n", n, n, ocr.Width, ocr.Height)
+		fmt.Fprintf(&sb, `<PrintSpace HPOS="0" VPOS="0" WIDTH="%d" HEIGHT="%d">`+"\n", ocr.Width, ocr.Height)
+		for b := range ocr.Blocks {
+			block := &ocr.Blocks[b]
+			id := fmt.Sprintf("%d_%d", n, b+1)
+			lang := ""
+			if len(block.Languages) > 0 {
+				lang = ` LANG="` + xmlText(block.Languages[0].Code) + `"`
+			}
+			fmt.Fprintf(&sb, `<TextBlock ID="block_%s"%s%s>`+"\n", id, altoPosition(layoutBox(block.Box, block.Polygon)), lang)
+			for l := range block.Lines {
+				line := &block.Lines[l]
+				lineID := fmt.Sprintf("%s_%d", id, l+1)
+				var strs []string
+				for w := range line.Words {
+					word := &line.Words[w]
+					content := aligned[word]
+					if content == "" {
+						continue
+					}
+					s := fmt.Sprintf(`<String ID="string_%s_%d" CONTENT="%s"%s`,
+						lineID, w+1, xmlText(content), altoPosition(layoutBox(word.Box, word.Polygon)))
+					if word.Confidence >= 0 {
+						s += fmt.Sprintf(` WC="%.2f"`, word.Confidence)
+					}
+					if content != word.Text {
+						s += `><ALTERNATIVE PURPOSE="OCR">` + xmlText(word.Text) + `</ALTERNATIVE></String>`
+					} else {
+						s += `/>`
+					}
+					strs = append(strs, s)
+				}
+				// ALTO требует хотя бы одно слово в строке
+				if len(strs) == 0 {
+					continue
+				}
+				fmt.Fprintf(&sb, `<TextLine ID="line_%s"%s>`+"\n", lineID, altoPosition(layoutBox(line.Box, line.Polygon)))
+				sb.WriteString(strings.Join(strs, "\n<SP/>\n"))
+				sb.WriteString("\n</TextLine>\n")
+			}
+			sb.WriteString("</TextBlock>\n")
+		}
+		sb.WriteString("</PrintSpace>\n</Page>\n")
+	}
+	sb.WriteString("</Layout>\n</alto>\n")
+	return []byte(sb.String())
+}
+
+func altoPosition(box BoundingBox) string {
+	return fmt.Sprintf(` HPOS="%d" VPOS="%d" WIDTH="%d" HEIGHT="%d"`, box.X, box.Y, box.Width, box.Height)
+}
+
+// buildPAGEXML renders every successfully recognized page as a PAGE XML
+// document. PAGE XML holds a single page per file, so several pages are
+// packed into a zip archive; the returned name tells which one it is.
+func buildPAGEXML(results []*Result, errs []error) (name string, data []byte, err error) {
+	now := time.Now()
+	type pageFile struct {
+		name string
+		body string
+	}
+	var files []pageFile
+	for i, result := range results {
+		if errs[i] != nil || result.OCR == nil {
+			continue
+		}
+		files = append(files, pageFile{fmt.Sprintf("page-%d.xml", i+1), pageXML(i+1, result, now)})
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("no recognized pages")
+	}
+	if len(results) == 1 {
+		return "result.xml", []byte(files[0].body), nil
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return "", nil, fmt.Errorf("create %s: %v", f.name, err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			return "", nil, fmt.Errorf("write %s: %v", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, fmt.Errorf("close zip: %v", err)
+	}
+	return "result.zip", buf.Bytes(), nil
+}
+
+// pageXML renders one page as PAGE XML. Text equivalents go from words up to
+// lines and regions; a changed word has the corrected text at index 1 and
+// the OCR reading at index 2.
+func pageXML(n int, result *Result, now time.Time) string {
+	ocr := result.OCR
+	aligned := alignCorrection(result)
+	stamp := now.Format("2006-01-02T15:04:05")
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<PcGts xmlns="` + pageNamespace + `" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ` +
+		`xsi:schemaLocation="` + pageNamespace + ` ` + pageSchema + `">` + "\n")
+	sb.WriteString("<Metadata>\n<Creator>" + exportCreator + "</Creator>\n")
+	sb.WriteString("<Created>" + stamp + "</Created>\n<LastChange>" + stamp + "</LastChange>\n")
+	comments := "engine=" + ocr.Engine + "; model=" + result.Profile.OCRModel
+	if result.Profile.Correct {
+		comments += "; correction=" + result.Profile.LLMModel
+	}
+	sb.WriteString("<Comments>" + xmlText(comments) + "</Comments>\n</Metadata>\n")
+	fmt.Fprintf(&sb, `<Page imageFilename="%s" imageWidth="%d" imageHeight="%d">`+"\n",
+		xmlText(pageImageName(n, result)), ocr.Width, ocr.Height)
+
+	for b := range ocr.Blocks {
+		block := &ocr.Blocks[b]
+		id := fmt.Sprintf("r%d", b+1)
+		lang := ""
+		if len(block.Languages) > 0 {
+			if name, ok := pageLanguageNames[block.Languages[0].Code]; ok {
+				lang = ` primaryLanguage="` + name + `"`
+			}
+		}
+		fmt.Fprintf(&sb, `<TextRegion id="%s" type="paragraph"%s>`+"\n", id, lang)
+		sb.WriteString(pageCoords(block.Box, block.Polygon))
+		var regionText []string
+		for l := range block.Lines {
+			line := &block.Lines[l]
+			lineID := fmt.Sprintf("%sl%d", id, l+1)
+			fmt.Fprintf(&sb, `<TextLine id="%s">`+"\n", lineID)
+			sb.WriteString(pageCoords(line.Box, line.Polygon))
+			var lineText []string
+			for w := range line.Words {
+				word := &line.Words[w]
+				content := aligned[word]
+				if content == "" {
+					continue
+				}
+				lineText = append(lineText, content)
+				fmt.Fprintf(&sb, `<Word id="%sw%d">`+"\n", lineID, w+1)
+				sb.WriteString(pageCoords(word.Box, word.Polygon))
+				if content != word.Text {
+					sb.WriteString(pageTextEquiv(1, ConfidenceUnknown, content))
+					sb.WriteString(pageTextEquiv(2, word.Confidence, word.Text))
+				} else {
+					sb.WriteString(pageTextEquiv(0, word.Confidence, content))
+				}
+				sb.WriteString("</Word>\n")
+			}
+			text := strings.Join(lineText, " ")
+			regionText = append(regionText, text)
+			sb.WriteString(pageTextEquiv(0, line.Confidence, text))
+			sb.WriteString("</TextLine>\n")
+		}
+		sb.WriteString(pageTextEquiv(0, ConfidenceUnknown, strings.Join(regionText, "\n")))
+		sb.WriteString("</TextRegion>\n")
+	}
+	sb.WriteString("</Page>\n</PcGts>\n")
+	return sb.String()
+}
+
+// pageLanguageNames maps language codes to the PAGE XML language names;
+// languages missing here are left out of the export.
+var pageLanguageNames = map[string]string{
+	"ru": "Russian",
+	"en": "English",
+	"uk": "Ukrainian",
+	"kk": "Kazakh",
+	"de": "German",
+	"fr": "French",
+	"es": "Spanish",
+	"it": "Italian",
+	"pl": "Polish",
+	"tr": "Turkish",
+}
+
+// pageCoords renders the outline of an element, the polygon when there is
+// one and the box corners otherwise. PAGE XML allows no negative points.
+func pageCoords(box BoundingBox, polygon []Point) string {
+	if len(polygon) < 3 {
+		box = layoutBox(box, polygon)
+		polygon = []Point{
+			{box.X, box.Y},
+			{box.X + box.Width, box.Y},
+			{box.X + box.Width, box.Y + box.Height},
+			{box.X, box.Y + box.Height},
+		}
+	}
+	points := make([]string, len(polygon))
+	for i, p := range polygon {
+		points[i] = fmt.Sprintf("%d,%d", max(p.X, 0), max(p.Y, 0))
+	}
+	return `<Coords points="` + strings.Join(points, " ") + `"/>` + "\n"
+}
+
+// pageTextEquiv renders a text equivalent; index 0 leaves it unindexed.
+func pageTextEquiv(index int, confidence float64, text string) string {
+	attrs := ""
+	if index > 0 {
+		attrs += fmt.Sprintf(` index="%d"`, index)
+	}
+	if confidence >= 0 {
+		attrs += fmt.Sprintf(` conf="%.2f"`, confidence)
+	}
+	return "<TextEquiv" + attrs + "><Unicode>" + xmlText(text) + "</Unicode></TextEquiv>\n"
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archivalResults is a two-page sample: a recognized page where the
+// corrector merged two words and appended one, and a failed page.
+func archivalResults() ([]*Result, []error) {
+	word := func(text string, x int, confidence float64) OCRWord {
+		return OCRWord{Text: text, Box: BoundingBox{X: x, Y: 10, Width: 30, Height: 20}, Confidence: confidence, EntityIndex: -1}
+	}
+	result := &Result{
+		Source:  Page{MimeType: "image/jpeg"},
+		OCRText: "Пре вет мир & <x>\nвторая строка",
+		Text:    "Привет мир & <x> ещё\nвторая строка",
+		Legible: true,
+		Profile: PipelineProfile{Name: ProfileBasic, OCRModel: "handwritten", Correct: true, LLMModel: "test"},
+		OCR: &OCRResult{
+			Engine: "yandex",
+			Width:  800,
+			Height: 600,
+			Blocks: []OCRBlock{{
+				Box:        BoundingBox{X: 0, Y: 0, Width: 400, Height: 100},
+				Languages:  []OCRLanguage{{Code: "ru", Confidence: 0.9}},
+				Confidence: ConfidenceUnknown,
+				Lines: []OCRLine{
+					{
+						Box:        BoundingBox{X: 0, Y: 0, Width: 400, Height: 40},
+						Confidence: 0.8,
+						Words:      []OCRWord{word("Пре", 0, 0.5), word("вет", 40, 0.6), word("мир", 80, 0.99), word("&", 120, ConfidenceUnknown), word("<x>", 160, 0.9)},
+					},
+					{
+						Polygon:    []Point{{0, 50}, {300, 50}, {300, 90}, {0, 90}},
+						Confidence: 0.9,
+						Words:      []OCRWord{word("вторая", 0, 0.9), word("строка", 100, 0.9)},
+					},
+				},
+			}},
+		},
+	}
+	return []*Result{result, nil}, []error{nil, errors.New("ocr failed")}
+}
+
+// validateSchema checks the document against a schema in testdata/schemas
+// with xmllint. Only a missing xmllint skips the check; the schemas are part
+// of the repository.
+func validateSchema(t *testing.T, doc []byte, schema string) {
+	t.Helper()
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint not installed")
+	}
+	dir, err := filepath.Abs(filepath.Join("testdata", "schemas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, schema)); err != nil {
+		t.Fatalf("%s missing, run testdata/schemas/fetch.sh and commit the files", schema)
+	}
+
+	file := filepath.Join(t.TempDir(), "doc.xml")
+	if err := os.WriteFile(file, doc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(xmllint, "--noout", "--nonet", "--schema", filepath.Join(dir, schema), file)
+	cmd.Env = append(os.Environ(), "XML_CATALOG_FILES="+filepath.Join(dir, "catalog.xml"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s validation failed: %v\n%s", schema, err, out)
+	}
+}
+
+func TestALTOSchema(t *testing.T) {
+	results, errs := archivalResults()
+	validateSchema(t, buildALTO(results, errs), "alto-4-4.xsd")
+}
+
+func TestPAGEXMLSchema(t *testing.T) {
+	results, errs := archivalResults()
+	_, data, err := buildPAGEXML(results[:1], errs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateSchema(t, data, "pagecontent-2019-07-15.xsd")
+}
+
+func TestALTOContent(t *testing.T) {
+	var doc struct {
+		Pages []struct {
+			ID      string `xml:"ID,attr"`
+			Strings []struct {
+				Content     string `xml:"CONTENT,attr"`
+				WC          string `xml:"WC,attr"`
+				Alternative string `xml:"ALTERNATIVE"`
+			} `xml:"PrintSpace>TextBlock>TextLine>String"`
+		} `xml:"Layout>Page"`
+	}
+	results, errs := archivalResults()
+	if err := xml.Unmarshal(buildALTO(results, errs), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Pages) != 2 || len(doc.Pages[1].Strings) != 0 {
+		t.Fatalf("pages = %+v", doc.Pages)
+	}
+
+	var got []string
+	for _, s := range doc.Pages[0].Strings {
+		got = append(got, s.Content+"|"+s.Alternative)
+	}
+	want := []string{"Привет|Пре", "мир|", "&|", "<x> ещё|<x>", "вторая|", "строка|"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("strings = %q, want %q", got, want)
+	}
+	if wc := doc.Pages[0].Strings[2].WC; wc != "" {
+		t.Errorf("unknown confidence written as WC=%q", wc)
+	}
+}
+
+func TestPAGEXMLContent(t *testing.T) {
+	var doc struct {
+		Page struct {
+			Regions []struct {
+				Language string `xml:"primaryLanguage,attr"`
+				Lines    []struct {
+					Words []struct {
+						Equivs []struct {
+							Index   string `xml:"index,attr"`
+							Unicode string `xml:"Unicode"`
+						} `xml:"TextEquiv"`
+					} `xml:"Word"`
+					Text string `xml:"TextEquiv>Unicode"`
+				} `xml:"TextLine"`
+			} `xml:"TextRegion"`
+		} `xml:"Page"`
+	}
+	results, errs := archivalResults()
+	name, data, err := buildPAGEXML(results[:1], errs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "result.xml" {
+		t.Errorf("name = %q", name)
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	region := doc.Page.Regions[0]
+	if region.Language != "Russian" {
+		t.Errorf("primaryLanguage = %q, want Russian", region.Language)
+	}
+	if text := region.Lines[0].Text; text != "Привет мир & <x> ещё" {
+		t.Errorf("line text = %q", text)
+	}
+	changed := region.Lines[0].Words[0].Equivs
+	if len(changed) != 2 || changed[0].Unicode != "Привет" || changed[1].Unicode != "Пре" || changed[1].Index != "2" {
+		t.Errorf("changed word equivs = %+v", changed)
+	}
+
+	// Несколько страниц упаковываются в архив
+	name, _, err = buildPAGEXML(results, errs)
+	if err != nil || name != "result.zip" {
+		t.Errorf("multi-page = %q, %v", name, err)
+	}
+}
+
+func TestHOCRWellFormed(t *testing.T) {
+	results, errs := archivalResults()
+	dec := xml.NewDecoder(strings.NewReader(string(buildHOCR(results, errs))))
+	words := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "class" && attr.Value == "ocrx_word" {
+				words++
+			}
+		}
+	}
+	if words != 6 {
+		t.Errorf("ocrx_word count = %d, want 6", words)
+	}
+}
@@ -1,85 +1,97 @@
-// config.go с поддержкой пользовательских настроек и кнопок
-package main
-
-import (
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-)
-
-type UserSettings struct {
-	Language string // Язык интерфейса
-	Format   string // Формат вывода
-	Model    string // Модель
-	Stage    string // Временное поле для отслеживания выбора
-}
-
-func DefaultSettings() *UserSettings {
-	return &UserSettings{
-		Language: "Русский",
-		Format:   "Простой текст",
-		Model:    "Базовая (быстрая)",
-		Stage:    "",
-	}
-}
-
-func settingsKeyboard(lang string) tgbotapi.ReplyKeyboardMarkup {
-	row1 := tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton(getLabel(lang, "change_lang")),
-		tgbotapi.NewKeyboardButton(getLabel(lang, "change_format")),
-	)
-	row2 := tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton(getLabel(lang, "change_model")),
-	)
-	return tgbotapi.NewReplyKeyboard(row1, row2)
-}
-
-func langKeyboard() tgbotapi.ReplyKeyboardMarkup {
-	return tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Русский"),
-			tgbotapi.NewKeyboardButton("Английский"),
-		),
-	)
-}
-
-func formatKeyboard(lang string) tgbotapi.ReplyKeyboardMarkup {
-	return tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton(getLabel(lang, "plain_text")),
-			tgbotapi.NewKeyboardButton("TXT-файл"),
-			tgbotapi.NewKeyboardButton("PDF-файл"),
-		),
-	)
-}
-
-func modelKeyboard(lang string) tgbotapi.ReplyKeyboardMarkup {
-	return tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton(getLabel(lang, "model_basic")),
-			tgbotapi.NewKeyboardButton(getLabel(lang, "model_improved")),
-		),
-	)
-}
-
-func getLabel(lang, key string) string {
-	en := map[string]string{
-		"change_lang":    "Change Language",
-		"change_format":  "Change Format",
-		"change_model":   "Change Model",
-		"plain_text":     "Plain Text",
-		"model_basic":    "Basic (fast)",
-		"model_improved": "Improved (accurate)",
-	}
-	ru := map[string]string{
-		"change_lang":    "Язык интерфейса",
-		"change_format":  "Формат ответа",
-		"change_model":   "Выбор модели",
-		"plain_text":     "Простой текст",
-		"model_basic":    "Базовая (быстрая)",
-		"model_improved": "Улучшенная (точная)",
-	}
-
-	if lang == "Английский" {
-		return en[key]
-	}
-	return ru[key]
-}
+// config.go с поддержкой пользовательских настроек и кнопок
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type UserSettings struct {
+	Language  string  `json:"language"`  // Код языка интерфейса, см. Languages
+	Format    string  `json:"format"`    // Формат вывода
+	Model     string  `json:"model"`     // Профиль конвейера: ProfileBasic или ProfileImproved
+	DocLang   string  `json:"doc_lang"`  // Язык документа: "auto" или коды через запятую, например "ru,en"
+	Engine    string  `json:"engine"`    // OCR-движок, пустая строка — движок из конфигурации
+	Highlight float64 `json:"highlight"` // Порог уверенности для подсветки сомнительных слов, 0 — выключено
+	ShowDiff  bool    `json:"show_diff"` // Всегда присылать изменения, внесённые моделью
+}
+
+func DefaultSettings() *UserSettings {
+	return &UserSettings{
+		Language:  defaultLanguage,
+		Format:    "Простой текст",
+		Model:     ProfileBasic,
+		DocLang:   "ru",
+		Engine:    "",
+		Highlight: 0,
+		ShowDiff:  false,
+	}
+}
+
+// highlightThresholds are the confidence thresholds offered in /settings.
+var highlightThresholds = []float64{0.5, 0.7, 0.9}
+
+// highlightLabel shows a highlight threshold as a percentage.
+func highlightLabel(lang string, threshold float64) string {
+	if threshold <= 0 {
+		return translate(lang, "highlight_off")
+	}
+	return fmt.Sprintf("%.0f%%", threshold*100)
+}
+
+func diffLabel(lang string, showDiff bool) string {
+	if showDiff {
+		return translate(lang, "diff_always")
+	}
+	return translate(lang, "diff_on_demand")
+}
+
+// docLanguages are the document language choices offered in /settings.
+// The "handwritten" OCR model used by every profile reads only Russian and
+// English, so other languages are not offered.
+var docLanguages = []string{"auto", "ru", "en", "ru,en"}
+
+// docLanguageCodes turns the document language setting into OCR language
+// codes.
+func docLanguageCodes(docLang string) []string {
+	if docLang == "" || docLang == "auto" {
+		return []string{AutoLanguage}
+	}
+	return strings.Split(docLang, ",")
+}
+
+// docLanguageLabel names the document languages in the interface language.
+func docLanguageLabel(lang, docLang string) string {
+	if docLang == "" || docLang == "auto" {
+		return translate(lang, "doc_lang_auto")
+	}
+	var names []string
+	for _, code := range strings.Split(docLang, ",") {
+		names = append(names, translate(lang, "lang_"+code))
+	}
+	return strings.Join(names, " + ")
+}
+
+// formatKeys are the tr keys naming each output format.
+var formatKeys = map[string]string{
+	"Простой текст": "plain_text",
+	"TXT-файл":      "format_txt",
+	"PDF-файл":      "format_pdf",
+	"PDF-скан":      "format_pdf_scan",
+	"DOCX-файл":     "format_docx",
+	"MD-файл":       "format_md",
+	"HTML-файл":     "format_html",
+	"Структурированный текст": "structured_text",
+	"hOCR":     "format_hocr",
+	"ALTO XML": "format_alto",
+	"PAGE XML": "format_page",
+	"JSON":     "format_json",
+}
+
+// formatLabel names an output format in the interface language.
+func formatLabel(lang, format string) string {
+	if key, ok := formatKeys[format]; ok {
+		return translate(lang, key)
+	}
+	return format
+}
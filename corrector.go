@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// correctionPrompt is the instruction sent to the LLM before the OCR text.
+// The model answers with a JSON object matching Correction.
+const correctionPrompt = "Исправьте ошибки OCR в тексте, сохраняя оригинальный язык и переносы строк. Исправляйте ТОЛЬКО явные орфографические ошибки или неполные слова на основе написания и контекста. Не добавляйте и не удаляйте слова, не изменяйте структуру, порядок слов, пунктуацию, смысл и самое главное - переносы строк, даже если текст нелогичен. Сводите исправления к минимуму. %s\n\nОтветьте только JSON-объектом без комментариев и разметки:\n{\"text\": \"исправленный текст\", \"legible\": true, \"uncertain\": [\"фрагмент\"]}\nгде text — исправленный текст с сохранёнными переносами строк (\\n), legible — false, если текст довольно неразборчивый, uncertain — слова или короткие фрагменты исправленного текста, в которых вы не уверены (пустой список, если таких нет).\n\n%s"
+
+// languageNames name OCR language codes in the language of correctionPrompt.
+var languageNames = map[string]string{
+	"ru": "русском",
+	"en": "английском",
+}
+
+// correctionLanguageHint tells the model which languages the text is in, or
+// to detect the language itself when none are known.
+func correctionLanguageHint(languages []string) string {
+	var names []string
+	for _, code := range languages {
+		if code == AutoLanguage {
+			continue
+		}
+		if name, ok := languageNames[code]; ok {
+			code = name
+		}
+		names = append(names, code)
+	}
+	if len(names) == 0 {
+		return "Определите язык текста сами и не переводите его."
+	}
+	if len(names) == 1 {
+		return "Текст написан на " + names[0] + " языке, не переводите его."
+	}
+	return "Текст написан на " + strings.Join(names, " и ") + " языках, не переводите его."
+}
+
+// legacyIllegibleMarker is what older prompts asked the model to append to
+// illegible text; models that ignore JSON mode sometimes still produce it.
+const legacyIllegibleMarker = "слишком неразборчиво 9905148"
+
+// Correction is the structured outcome of the correction step.
+type Correction struct {
+	Text      string   `json:"text"`      // Corrected text
+	Legible   bool     `json:"legible"`   // False when the handwriting was too hard to read
+	Uncertain []string `json:"uncertain"` // Fragments of Text the model is unsure about
+}
+
+// parseCorrection decodes the model's JSON answer. Answers that are not
+// valid JSON are taken as plain corrected text. The text may come back
+// empty, e.g. for an illegible page; the caller then keeps the OCR text.
+func parseCorrection(content string) *Correction {
+	content = strings.TrimSpace(content)
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```"), "```")
+
+	c := Correction{Legible: true} // A missing verdict is not a warning
+	if err := json.Unmarshal([]byte(strings.TrimSpace(trimmed)), &c); err == nil {
+		c.Text = strings.TrimSpace(c.Text)
+		return &c
+	}
+
+	legible := !strings.Contains(content, legacyIllegibleMarker)
+	content = strings.TrimSpace(strings.ReplaceAll(content, legacyIllegibleMarker, ""))
+	return &Correction{Text: content, Legible: legible}
+}
+
+// CorrectOptions tunes a single correction request.
+type CorrectOptions struct {
+	Model       string        // Overrides the corrector's default model
+	Temperature float64       // Sampling temperature
+	MaxTokens   int           // Upper bound on the completion length
+	Languages   []string      // Languages of the text, empty when unknown
+	Timeout     time.Duration // Limit for the whole call including retries, 90s when 0
+}
+
+// Corrector post-processes raw OCR text, fixing recognition errors.
+type Corrector interface {
+	Name() string
+	Correct(text string, opts CorrectOptions) (*Correction, error)
+}
+
+// ChatCompletionResponse defines the structure for OpenAI-compatible
+// chat completions responses, including Mistral's.
+type ChatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+			Role    string `json:"role"`
+		} `json:"message"`
+	} `json:"choices"`
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// ChatCompletionsCorrector corrects text through any OpenAI-compatible
+// /chat/completions endpoint: Mistral, OpenAI, Ollama, llama.cpp server,
+// vLLM or a YandexGPT-compatible gateway.
+type ChatCompletionsCorrector struct {
+	name     string
+	URL      string
+	APIKey   string // Optional for local servers
+	Model    string
+	JSONMode bool // Request response_format json_object
+	Client   *http.Client
+}
+
+var (
+	correctorsMu sync.RWMutex
+	correctors   = map[string]func() (Corrector, error){
+		"mistral": newMistralCorrector,
+		"openai":  newOpenAICorrector,
+		"none":    func() (Corrector, error) { return noopCorrector{}, nil },
+	}
+)
+
+// RegisterCorrector makes a correction backend available under name.
+func RegisterCorrector(name string, factory func() (Corrector, error)) {
+	correctorsMu.Lock()
+	defer correctorsMu.Unlock()
+	correctors[name] = factory
+}
+
+// CorrectorNames lists the registered correction backends.
+func CorrectorNames() []string {
+	correctorsMu.RLock()
+	defer correctorsMu.RUnlock()
+	names := make([]string, 0, len(correctors))
+	for name := range correctors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCorrector builds the backend selected with CORRECTOR (default "mistral").
+func NewCorrector() (Corrector, error) {
+	name := os.Getenv("CORRECTOR")
+	if name == "" {
+		name = "mistral"
+	}
+	correctorsMu.RLock()
+	factory, ok := correctors[name]
+	correctorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown corrector %q", name)
+	}
+	return factory()
+}
+
+// newMistralCorrector configures the Mistral Chat API.
+// Requires MISTRAL_API_KEY environment variable.
+// On Windows, set DNS to 8.8.8.8 or 1.1.1.1 if DNS resolution fails (Control Panel > Network > Adapter > IPv4 > DNS).
+func newMistralCorrector() (Corrector, error) {
+	apiKey := os.Getenv("MISTRAL_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: MISTRAL_API_KEY not set", ErrNotConfigured)
+	}
+	model := os.Getenv("MISTRAL_MODEL")
+	if model == "" {
+		model = "mistral-large-latest" // Default per Mistral API docs
+	}
+	client, err := sharedHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &ChatCompletionsCorrector{
+		name:     "mistral",
+		URL:      "https://api.mistral.ai/v1/chat/completions",
+		APIKey:   apiKey,
+		Model:    model,
+		JSONMode: true,
+		Client:   client,
+	}, nil
+}
+
+// newOpenAICorrector configures an OpenAI-compatible server from
+// OPENAI_BASE_URL (e.g. http://localhost:11434/v1 for Ollama), OPENAI_MODEL
+// and an optional OPENAI_API_KEY. Set OPENAI_JSON_MODE=false for servers
+// that reject response_format.
+func newOpenAICorrector() (Corrector, error) {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	model := os.Getenv("OPENAI_MODEL")
+	if baseURL == "" || model == "" {
+		return nil, fmt.Errorf("%w: OPENAI_BASE_URL or OPENAI_MODEL not set", ErrNotConfigured)
+	}
+	client, err := sharedHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &ChatCompletionsCorrector{
+		name:     "openai",
+		URL:      strings.TrimRight(baseURL, "/") + "/chat/completions",
+		APIKey:   os.Getenv("OPENAI_API_KEY"),
+		Model:    model,
+		JSONMode: os.Getenv("OPENAI_JSON_MODE") != "false",
+		Client:   client,
+	}, nil
+}
+
+var (
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+	httpClientErr  error
+)
+
+// sharedHTTPClient returns the client used by all correctors. It is built on
+// first use, which main triggers at startup, and reused by every job.
+func sharedHTTPClient() (*http.Client, error) {
+	httpClientOnce.Do(func() {
+		httpClient, httpClientErr = proxyHTTPClient()
+	})
+	return httpClient, httpClientErr
+}
+
+// proxySettings reads USE_PROXY and PROXY_ADDR.
+func proxySettings() (useProxy bool, proxyAddr string) {
+	useProxy = os.Getenv("USE_PROXY") == "true" // Default to false unless explicitly true
+	proxyAddr = os.Getenv("PROXY_ADDR")
+	if proxyAddr == "" {
+		proxyAddr = "127.0.0.1:10808"
+	}
+	return useProxy, proxyAddr
+}
+
+// checkProxyIP logs the public IP address for debugging network issues.
+// main calls it once at startup.
+func checkProxyIP() {
+	useProxy, proxyAddr := proxySettings()
+	fmt.Printf("Checking IP (Proxy: %v, Addr: %s)...\n", useProxy, proxyAddr)
+	ip, err := checkIP(useProxy, proxyAddr)
+	logIP(ip, useProxy, proxyAddr, err)
+	if err != nil {
+		fmt.Printf("IP check failed: %v\n", err)
+	}
+}
+
+// proxyHTTPClient builds an HTTP client honouring USE_PROXY and PROXY_ADDR.
+func proxyHTTPClient() (*http.Client, error) {
+	useProxy, proxyAddr := proxySettings()
+	if !useProxy {
+		return &http.Client{}, nil
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("setup SOCKS5 proxy: %v", err)
+	}
+	// Без таймаута клиента: запрос ограничивает контекст из Correct
+	return &http.Client{Transport: &http.Transport{Dial: dialer.Dial}}, nil
+}
+
+// Name implements Corrector.
+func (c *ChatCompletionsCorrector) Name() string { return c.name }
+
+// Correct implements Corrector.
+func (c *ChatCompletionsCorrector) Correct(text string, opts CorrectOptions) (*Correction, error) {
+	model := opts.Model
+	if model == "" {
+		model = c.Model
+	}
+	maxTokens := opts.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 2000
+	}
+
+	payload := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": fmt.Sprintf(correctionPrompt, correctionLanguageHint(opts.Languages), text),
+			},
+		},
+		"temperature": opts.Temperature,
+		"max_tokens":  maxTokens,
+	}
+	if c.JSONMode {
+		payload["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %v", err)
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 90 * time.Second // Covers all attempts
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fmt.Printf("%s Request Body: %s\n", c.name, string(body))
+
+	// Retry logic for transient network issues
+	maxRetries := 3
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		fmt.Printf("%s API attempt %d/%d at %s\n", c.name, attempt, maxRetries, time.Now().Format(time.RFC3339))
+		req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			fmt.Printf("Attempt %d failed: %v\n", attempt, err)
+			if attempt < maxRetries && ctx.Err() == nil {
+				time.Sleep(time.Duration(attempt) * time.Second) // Exponential backoff
+				continue
+			}
+			return nil, fmt.Errorf("send request after %d attempts: %v", maxRetries, err)
+		}
+
+		fmt.Printf("%s Response Status: %d\n", c.name, resp.StatusCode)
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Printf("Attempt %d failed to read response: %v\n", attempt, err)
+			if attempt < maxRetries {
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("read response after %d attempts: %v", maxRetries, err)
+		}
+
+		// Save response for debugging
+		responseFile := c.name + "_response.json"
+		if err := os.WriteFile(responseFile, respBody, 0644); err != nil {
+			fmt.Printf("Error writing %s: %v\n", responseFile, err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Attempt %d failed with status: %d, body: %s\n", attempt, resp.StatusCode, string(respBody))
+			if attempt < maxRetries {
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("%s failed: status %d, body: %s", c.name, resp.StatusCode, string(respBody))
+		}
+
+		var chatResp ChatCompletionResponse
+		if err := json.Unmarshal(respBody, &chatResp); err != nil {
+			fmt.Printf("Attempt %d failed to unmarshal: %v\n", attempt, err)
+			if attempt < maxRetries {
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("unmarshal response after %d attempts: %v", maxRetries, err)
+		}
+
+		if chatResp.Error.Message != "" {
+			return nil, fmt.Errorf("%s error: %s (type: %s)", c.name, chatResp.Error.Message, chatResp.Error.Type)
+		}
+
+		if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message.Content == "" {
+			fmt.Printf("Attempt %d: no valid response, body: %s\n", attempt, string(respBody))
+			if attempt < maxRetries {
+				time.Sleep(time.Duration(attempt) * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("no %s response, body: %s", c.name, string(respBody))
+		}
+
+		return parseCorrection(chatResp.Choices[0].Message.Content), nil
+	}
+
+	return nil, fmt.Errorf("%s request failed after %d attempts", c.name, maxRetries)
+}
+
+// noopCorrector returns the OCR text unchanged.
+type noopCorrector struct{}
+
+func (noopCorrector) Name() string { return "none" }
+
+func (noopCorrector) Correct(text string, _ CorrectOptions) (*Correction, error) {
+	return &Correction{Text: text, Legible: true}, nil
+}
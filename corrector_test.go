@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCorrection(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		text      string
+		legible   bool
+		uncertain []string
+	}{
+		{"json", `{"text":" Привет ","legible":true,"uncertain":["мир"]}`, "Привет", true, []string{"мир"}},
+		{"fenced json", "```json\n{\"text\":\"Привет\",\"legible\":false}\n```", "Привет", false, nil},
+		{"empty text keeps verdict", `{"text":"","legible":false,"uncertain":[]}`, "", false, []string{}},
+		{"missing verdict", `{"text":"Привет"}`, "Привет", true, nil},
+		{"plain text", "Привет, мир", "Привет, мир", true, nil},
+		{"legacy marker", "Привет " + legacyIllegibleMarker, "Привет", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parseCorrection(tt.content)
+			if c.Text != tt.text || c.Legible != tt.legible || !slices.Equal(c.Uncertain, tt.uncertain) {
+				t.Errorf("parseCorrection(%q) = %+v, want text %q, legible %v, uncertain %q",
+					tt.content, *c, tt.text, tt.legible, tt.uncertain)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DialogEnd is the transition target that finishes a dialog.
+const DialogEnd = "end"
+
+var (
+	// ErrNoDialog is returned when the chat has no active dialog.
+	ErrNoDialog = errors.New("no active dialog")
+	// ErrDialogExpired is returned when the chat's dialog timed out.
+	ErrDialogExpired = errors.New("dialog expired")
+)
+
+// DialogChoice is an answer the user can pick instead of typing it.
+type DialogChoice struct {
+	Label string
+	Input string
+}
+
+// DialogView is what a dialog shows after entering a state. It does not
+// depend on Telegram: the bot renders Choices as inline buttons whose
+// callback data is the choice input.
+type DialogView struct {
+	Text    string
+	Choices [][]DialogChoice
+	Notice  string // Short feedback on the last input, e.g. a confirmation
+	Done    bool   // The dialog has finished
+}
+
+// Transition is the outcome of handling an input in a state.
+type Transition struct {
+	Next   string // State to enter; "" stays in the current state, DialogEnd finishes
+	Notice string
+}
+
+// DialogContext is the state of one running dialog.
+type DialogContext struct {
+	ChatID int64
+	State  string
+	Data   map[string]string // Values collected by earlier steps
+}
+
+// DialogState declares how a state is shown and how it reacts to input.
+type DialogState struct {
+	Enter  func(c *DialogContext) DialogView
+	Handle func(c *DialogContext, input string) Transition
+}
+
+// Dialog declares a multi-step conversation as a set of named states.
+type Dialog struct {
+	Name    string
+	Start   string
+	Timeout time.Duration // Idle time after which the dialog is dropped, 0 for none
+	States  map[string]DialogState
+	Finish  func(c *DialogContext) DialogView // View shown when the dialog ends
+}
+
+type runningDialog struct {
+	dialog  *Dialog
+	ctx     *DialogContext
+	expires time.Time
+}
+
+// Dialogs runs at most one dialog per chat. Inputs of the same chat must not
+// be handled concurrently; the bot's SessionManager guarantees that.
+type Dialogs struct {
+	mu      sync.Mutex
+	dialogs map[string]*Dialog
+	active  map[int64]*runningDialog
+	now     func() time.Time
+}
+
+// NewDialogs returns a runner for the given dialogs.
+func NewDialogs(dialogs ...*Dialog) *Dialogs {
+	d := &Dialogs{
+		dialogs: make(map[string]*Dialog),
+		active:  make(map[int64]*runningDialog),
+		now:     time.Now,
+	}
+	for _, dialog := range dialogs {
+		d.dialogs[dialog.Name] = dialog
+	}
+	return d
+}
+
+// Start begins the named dialog for the chat, replacing any running one.
+func (d *Dialogs) Start(chatID int64, name string) (DialogView, error) {
+	d.mu.Lock()
+	dialog, ok := d.dialogs[name]
+	d.mu.Unlock()
+	if !ok {
+		return DialogView{}, fmt.Errorf("unknown dialog %q", name)
+	}
+	state, ok := dialog.States[dialog.Start]
+	if !ok {
+		return DialogView{}, fmt.Errorf("dialog %s: unknown start state %q", name, dialog.Start)
+	}
+
+	run := &runningDialog{
+		dialog: dialog,
+		ctx:    &DialogContext{ChatID: chatID, State: dialog.Start, Data: make(map[string]string)},
+	}
+	d.mu.Lock()
+	d.touch(run)
+	d.active[chatID] = run
+	d.mu.Unlock()
+	return state.Enter(run.ctx), nil
+}
+
+// Handle feeds an input to the chat's dialog and returns the view of the
+// state it moved to.
+func (d *Dialogs) Handle(chatID int64, input string) (DialogView, error) {
+	run, err := d.running(chatID)
+	if err != nil {
+		return DialogView{}, err
+	}
+	dialog, ctx := run.dialog, run.ctx
+
+	t := dialog.States[ctx.State].Handle(ctx, input)
+	next := t.Next
+	if next == "" {
+		next = ctx.State
+	}
+	if next == DialogEnd {
+		d.end(chatID, run)
+		view := DialogView{}
+		if dialog.Finish != nil {
+			view = dialog.Finish(ctx)
+		}
+		view.Notice, view.Done = t.Notice, true
+		return view, nil
+	}
+
+	state, ok := dialog.States[next]
+	if !ok {
+		d.end(chatID, run)
+		return DialogView{}, fmt.Errorf("dialog %s: unknown state %q", dialog.Name, next)
+	}
+	ctx.State = next
+	d.mu.Lock()
+	d.touch(run)
+	d.mu.Unlock()
+
+	view := state.Enter(ctx)
+	view.Notice = t.Notice
+	return view, nil
+}
+
+// Cancel drops the chat's dialog and reports whether one was running.
+func (d *Dialogs) Cancel(chatID int64) bool {
+	run, err := d.running(chatID)
+	if err != nil {
+		return false
+	}
+	d.end(chatID, run)
+	return true
+}
+
+// Active reports whether the chat has a dialog waiting for input.
+func (d *Dialogs) Active(chatID int64) bool {
+	_, err := d.running(chatID)
+	return err == nil
+}
+
+func (d *Dialogs) running(chatID int64) (*runningDialog, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run, ok := d.active[chatID]
+	if !ok {
+		return nil, ErrNoDialog
+	}
+	if !run.expires.IsZero() && d.now().After(run.expires) {
+		delete(d.active, chatID)
+		return nil, ErrDialogExpired
+	}
+	return run, nil
+}
+
+func (d *Dialogs) end(chatID int64, run *runningDialog) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Диалог мог быть уже заменён новым
+	if d.active[chatID] == run {
+		delete(d.active, chatID)
+	}
+}
+
+// touch extends the dialog's timeout; d.mu must be held.
+func (d *Dialogs) touch(run *runningDialog) {
+	if run.dialog.Timeout > 0 {
+		run.expires = d.now().Add(run.dialog.Timeout)
+	}
+}
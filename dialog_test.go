@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDialog asks for a size, then a color, and finishes with both.
+func testDialog() *Dialog {
+	choose := func(key, next string, valid ...string) DialogState {
+		return DialogState{
+			Enter: func(c *DialogContext) DialogView {
+				var row []DialogChoice
+				for _, v := range valid {
+					row = append(row, DialogChoice{Label: v, Input: v})
+				}
+				return DialogView{Text: key, Choices: [][]DialogChoice{row}}
+			},
+			Handle: func(c *DialogContext, input string) Transition {
+				if input == "cancel" {
+					return Transition{Next: DialogEnd, Notice: "cancelled"}
+				}
+				for _, v := range valid {
+					if input == v {
+						c.Data[key] = input
+						return Transition{Next: next, Notice: key + " set"}
+					}
+				}
+				return Transition{Notice: "invalid"}
+			},
+		}
+	}
+	return &Dialog{
+		Name:    "order",
+		Start:   "size",
+		Timeout: time.Minute,
+		States: map[string]DialogState{
+			"size":  choose("size", "color", "S", "M"),
+			"color": choose("color", DialogEnd, "red", "blue"),
+		},
+		Finish: func(c *DialogContext) DialogView {
+			return DialogView{Text: c.Data["size"] + " " + c.Data["color"]}
+		},
+	}
+}
+
+func TestDialogsStartAndTransitions(t *testing.T) {
+	d := NewDialogs(testDialog())
+
+	view, err := d.Start(1, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Text != "size" || len(view.Choices) != 1 || len(view.Choices[0]) != 2 {
+		t.Fatalf("start view = %+v", view)
+	}
+	if !d.Active(1) || d.Active(2) {
+		t.Fatal("only chat 1 should have a dialog")
+	}
+
+	view, err = d.Handle(1, "M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Text != "color" || view.Notice != "size set" || view.Done {
+		t.Fatalf("view after size = %+v", view)
+	}
+
+	view, err = d.Handle(1, "blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !view.Done || view.Text != "M blue" || view.Notice != "color set" {
+		t.Fatalf("final view = %+v", view)
+	}
+	if d.Active(1) {
+		t.Fatal("dialog still active after DialogEnd")
+	}
+	if _, err := d.Handle(1, "red"); !errors.Is(err, ErrNoDialog) {
+		t.Fatalf("Handle after end: err = %v, want ErrNoDialog", err)
+	}
+}
+
+func TestDialogsInvalidInputStays(t *testing.T) {
+	d := NewDialogs(testDialog())
+	if _, err := d.Start(1, "order"); err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := d.Handle(1, "XL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Text != "size" || view.Notice != "invalid" || view.Done {
+		t.Fatalf("view after invalid input = %+v", view)
+	}
+	if !d.Active(1) {
+		t.Fatal("invalid input ended the dialog")
+	}
+}
+
+func TestDialogsEndFromHandler(t *testing.T) {
+	d := NewDialogs(testDialog())
+	if _, err := d.Start(1, "order"); err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := d.Handle(1, "cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !view.Done || view.Notice != "cancelled" {
+		t.Fatalf("view = %+v", view)
+	}
+	if d.Active(1) {
+		t.Fatal("dialog still active")
+	}
+}
+
+func TestDialogsCancel(t *testing.T) {
+	d := NewDialogs(testDialog())
+	if d.Cancel(1) {
+		t.Fatal("Cancel without a dialog reported true")
+	}
+	if _, err := d.Start(1, "order"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Cancel(1) {
+		t.Fatal("Cancel of a running dialog reported false")
+	}
+	if _, err := d.Handle(1, "S"); !errors.Is(err, ErrNoDialog) {
+		t.Fatalf("Handle after Cancel: err = %v, want ErrNoDialog", err)
+	}
+}
+
+func TestDialogsExpire(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDialogs(testDialog())
+	d.now = func() time.Time { return now }
+
+	if _, err := d.Start(1, "order"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Каждый ответ продлевает таймаут
+	now = now.Add(50 * time.Second)
+	if _, err := d.Handle(1, "S"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(50 * time.Second)
+	if !d.Active(1) {
+		t.Fatal("dialog expired although the user answered in time")
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := d.Handle(1, "red"); !errors.Is(err, ErrDialogExpired) {
+		t.Fatalf("err = %v, want ErrDialogExpired", err)
+	}
+	if _, err := d.Handle(1, "red"); !errors.Is(err, ErrNoDialog) {
+		t.Fatalf("err after expiry = %v, want ErrNoDialog", err)
+	}
+}
+
+func TestDialogsUnknown(t *testing.T) {
+	d := NewDialogs(testDialog())
+	if _, err := d.Start(1, "missing"); err == nil {
+		t.Fatal("Start of an unknown dialog succeeded")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// DiffOp is the kind of a word-level edit.
+type DiffOp int
+
+const (
+	DiffEqual  DiffOp = iota // Word present in both texts
+	DiffDelete               // Word only in the original text
+	DiffInsert               // Word only in the new text
+)
+
+// DiffEdit is one step turning the original word list into the new one.
+// A and B index the word in the original and new lists, -1 when absent.
+type DiffEdit struct {
+	Op   DiffOp
+	Text string
+	A, B int
+}
+
+// diffWords computes a minimal word-level edit script from a to b using the
+// longest common subsequence. Deletions come before insertions at each
+// change so replaced words read naturally.
+func diffWords(a, b []string) []DiffEdit {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]DiffEdit, 0, max(len(a), len(b)))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, DiffEdit{Op: DiffEqual, Text: b[j], A: i, B: j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, DiffEdit{Op: DiffDelete, Text: a[i], A: i, B: -1})
+			i++
+		default:
+			edits = append(edits, DiffEdit{Op: DiffInsert, Text: b[j], A: -1, B: j})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, DiffEdit{Op: DiffDelete, Text: a[i], A: i, B: -1})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, DiffEdit{Op: DiffInsert, Text: b[j], A: -1, B: j})
+	}
+	return edits
+}
+
+// diffHTML renders a word-level diff from the OCR text to the corrected
+// text for Telegram's HTML parse mode: removed words are struck through and
+// added ones are bold. Line breaks of the corrected text are kept.
+func diffHTML(ocrText, text string) string {
+	locs := wordPattern.FindAllStringIndex(text, -1)
+	words := make([]string, len(locs))
+	for i, loc := range locs {
+		words[i] = text[loc[0]:loc[1]]
+	}
+
+	var sb strings.Builder
+	var removed []string
+	flush := func() {
+		if len(removed) > 0 {
+			sb.WriteString("<s>" + html.EscapeString(strings.Join(removed, " ")) + "</s>")
+			removed = nil
+		}
+	}
+	prev := 0
+	for _, edit := range diffWords(strings.Fields(ocrText), words) {
+		if edit.Op == DiffDelete {
+			removed = append(removed, edit.Text)
+			continue
+		}
+		loc := locs[edit.B]
+		sb.WriteString(html.EscapeString(text[prev:loc[0]]))
+		if len(removed) > 0 {
+			flush()
+			sb.WriteString(" ")
+		}
+		if edit.Op == DiffInsert {
+			sb.WriteString("<b>" + html.EscapeString(edit.Text) + "</b>")
+		} else {
+			sb.WriteString(html.EscapeString(edit.Text))
+		}
+		prev = loc[1]
+	}
+	if len(removed) > 0 && prev > 0 {
+		sb.WriteString(" ")
+	}
+	flush()
+	sb.WriteString(html.EscapeString(text[prev:]))
+	return sb.String()
+}
+
+// alignCorrection maps every OCR word to the corrected text that replaced
+// it. Unchanged words map to themselves, words the corrector rewrote share
+// the replacement between them, inserted words join the preceding OCR word
+// and deleted words map to "".
+func alignCorrection(result *Result) map[*OCRWord]string {
+	aligned := make(map[*OCRWord]string)
+	if result.OCR == nil {
+		return aligned
+	}
+	var words []string
+	var owners []*OCRWord
+	for b := range result.OCR.Blocks {
+		for l := range result.OCR.Blocks[b].Lines {
+			line := &result.OCR.Blocks[b].Lines[l]
+			for w := range line.Words {
+				word := &line.Words[w]
+				aligned[word] = ""
+				for _, f := range strings.Fields(word.Text) {
+					words = append(words, f)
+					owners = append(owners, word)
+				}
+			}
+		}
+	}
+	if len(words) == 0 {
+		return aligned
+	}
+
+	parts := make([][]string, len(words))
+	var deleted []int
+	var inserted []string
+	last := -1 // Последнее слово OCR перед текущей правкой
+	flush := func() {
+		switch {
+		case len(inserted) == 0:
+		case len(deleted) == 0 && last < 0:
+			parts[0] = append(inserted, parts[0]...)
+		case len(deleted) == 0:
+			parts[last] = append(parts[last], inserted...)
+		default:
+			// Округляем вверх, чтобы при слиянии слов текст достался первому
+			k, m := len(deleted), len(inserted)
+			for j, a := range deleted {
+				from, to := (j*m+k-1)/k, ((j+1)*m+k-1)/k
+				parts[a] = append(parts[a], inserted[from:to]...)
+			}
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, edit := range diffWords(words, strings.Fields(result.Text)) {
+		switch edit.Op {
+		case DiffEqual:
+			flush()
+			parts[edit.A] = append(parts[edit.A], edit.Text)
+			last = edit.A
+		case DiffDelete:
+			deleted = append(deleted, edit.A)
+		case DiffInsert:
+			inserted = append(inserted, edit.Text)
+		}
+	}
+	flush()
+
+	for i, word := range owners {
+		if len(parts[i]) == 0 {
+			continue
+		}
+		if aligned[word] != "" {
+			aligned[word] += " "
+		}
+		aligned[word] += strings.Join(parts[i], " ")
+	}
+	return aligned
+}
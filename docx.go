@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// docxHeading reports whether DOCX files start with a title and the date
+// and number their pages. DOCX_HEADING=false turns it off.
+func docxHeading() bool {
+	return os.Getenv("DOCX_HEADING") != "false"
+}
+
+const docxNamespaces = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+
+// docxStaticParts are the package parts that do not depend on the content.
+var docxStaticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/footer1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`},
+	{"word/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/footer" Target="footer1.xml"/>
+</Relationships>`},
+	// Номер страницы и общее число страниц Word подставляет сам
+	{"word/footer1.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:ftr ` + docxNamespaces + `><w:p><w:pPr><w:jc w:val="center"/></w:pPr>` +
+		`<w:fldSimple w:instr=" PAGE "><w:r><w:t>1</w:t></w:r></w:fldSimple>` +
+		`<w:r><w:t xml:space="preserve"> / </w:t></w:r>` +
+		`<w:fldSimple w:instr=" NUMPAGES "><w:r><w:t>1</w:t></w:r></w:fldSimple></w:p></w:ftr>`},
+}
+
+// buildDOCX renders the results as an Office Open XML document. Paragraphs
+// follow the OCR blocks, lines within a block become line breaks. With a
+// positive highlight threshold doubtful words are colored red.
+func buildDOCX(chatID int64, results []*Result, errs []error, highlight float64) ([]byte, error) {
+	heading := docxHeading()
+	now := time.Now()
+
+	var body strings.Builder
+	if heading {
+		title := tr(chatID, "docx_title") + " — " + now.Format("02.01.2006")
+		body.WriteString(`<w:p><w:pPr><w:spacing w:after="240"/></w:pPr>`)
+		body.WriteString(docxRun(title, `<w:b/><w:sz w:val="32"/>`))
+		body.WriteString(`</w:p>`)
+	}
+	for i, result := range results {
+		if len(results) > 1 {
+			// Порядок элементов pPr задан схемой WordprocessingML
+			pPr := `<w:keepNext/>`
+			if i > 0 {
+				pPr += `<w:pageBreakBefore/>`
+			}
+			pPr += `<w:spacing w:before="240" w:after="120"/>`
+			body.WriteString(`<w:p><w:pPr>` + pPr + `</w:pPr>`)
+			body.WriteString(docxRun(pageHeader(chatID, i+1, len(results)), `<w:b/><w:sz w:val="26"/>`))
+			body.WriteString(`</w:p>`)
+		}
+		if errs[i] != nil {
+			body.WriteString(`<w:p>` + docxRun(fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[i]), "") + `</w:p>`)
+			continue
+		}
+		writeDOCXText(&body, result, highlight)
+	}
+
+	sectPr := `<w:sectPr>`
+	if heading {
+		sectPr += `<w:footerReference w:type="default" r:id="rId1"/>`
+	}
+	sectPr += `<w:pgSz w:w="11906" w:h="16838"/>` +
+		`<w:pgMar w:top="1134" w:right="850" w:bottom="1134" w:left="1701" w:header="708" w:footer="708" w:gutter="0"/>` +
+		`</w:sectPr>`
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<w:document ` + docxNamespaces + `><w:body>` + body.String() + sectPr + `</w:body></w:document>`
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, body string) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return fmt.Errorf("create %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			return fmt.Errorf("write %s: %v", name, err)
+		}
+		return nil
+	}
+	for _, part := range docxStaticParts {
+		if err := add(part.name, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := add("word/document.xml", document); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("close docx: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDOCXText writes the result text as paragraphs. A newline that ends an
+// OCR block starts a new paragraph, other newlines become line breaks.
+func writeDOCXText(body *strings.Builder, result *Result, highlight float64) {
+	ends := paragraphEnds(result)
+	line := 0
+	body.WriteString(`<w:p>`)
+	for _, span := range highlightSpans(result, highlight) {
+		props := ""
+		if span.Marked {
+			props = `<w:color w:val="C00000"/>`
+		}
+		for i, part := range strings.Split(span.Text, "\n") {
+			if i > 0 {
+				if ends[line] {
+					body.WriteString(`</w:p><w:p>`)
+				} else {
+					body.WriteString(`<w:r><w:br/></w:r>`)
+				}
+				line++
+			}
+			if part != "" {
+				body.WriteString(docxRun(part, props))
+			}
+		}
+	}
+	body.WriteString(`</w:p>`)
+}
+
+// paragraphEnds returns the indices of text lines that close a paragraph.
+// When the corrected text kept the OCR line count, paragraphs are the OCR
+// blocks; otherwise every line is a paragraph of its own.
+func paragraphEnds(result *Result) map[int]bool {
+	lines := strings.Count(result.Text, "\n") + 1
+	ends := make(map[int]bool)
+
+	ocrLines := 0
+	if result.OCR != nil {
+		for _, block := range result.OCR.Blocks {
+			ocrLines += len(block.Lines)
+		}
+	}
+	if ocrLines != lines {
+		for i := 0; i < lines; i++ {
+			ends[i] = true
+		}
+		return ends
+	}
+
+	last := -1
+	for _, block := range result.OCR.Blocks {
+		last += len(block.Lines)
+		ends[last] = true
+	}
+	return ends
+}
+
+// docxRun returns a text run with the given run properties.
+func docxRun(text, props string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	run := `<w:r>`
+	if props != "" {
+		run += `<w:rPr>` + props + `</w:rPr>`
+	}
+	return run + `<w:t xml:space="preserve">` + escaped.String() + `</w:t></w:r>`
+}
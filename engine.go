@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotConfigured is returned when an OCR engine or corrector lacks
+// credentials or other required settings.
+var ErrNotConfigured = errors.New("not configured")
+
+// OCROptions describes how an image should be recognized.
+type OCROptions struct {
+	MimeType      string        // MIME type of the image bytes, e.g. "image/jpeg"
+	LanguageCodes []string      // Expected document languages, AutoLanguage to detect
+	Model         string        // Engine-specific recognition model
+	Timeout       time.Duration // Limit for the whole recognition, 0 for the engine default
+}
+
+// AutoLanguage as the only language code asks the engine to detect the
+// document language.
+const AutoLanguage = "*"
+
+// ConfidenceUnknown marks a confidence the engine did not report.
+const ConfidenceUnknown = -1.0
+
+// Point is a vertex in image pixels.
+type Point struct {
+	X, Y int
+}
+
+// BoundingBox is an axis-aligned rectangle in image pixels.
+type BoundingBox struct {
+	X, Y, Width, Height int
+}
+
+// Empty reports whether the engine did not provide a box.
+func (b BoundingBox) Empty() bool {
+	return b.Width <= 0 || b.Height <= 0
+}
+
+// polygonBox returns the rectangle enclosing a polygon.
+func polygonBox(points []Point) BoundingBox {
+	if len(points) == 0 {
+		return BoundingBox{}
+	}
+	minX, minY := points[0].X, points[0].Y
+	maxX, maxY := minX, minY
+	for _, p := range points[1:] {
+		minX, maxX = min(minX, p.X), max(maxX, p.X)
+		minY, maxY = min(minY, p.Y), max(maxY, p.Y)
+	}
+	return BoundingBox{X: minX, Y: minY, Width: maxX - minX, Height: maxY - minY}
+}
+
+// TextSegment locates an entity inside OCRResult.FullText.
+type TextSegment struct {
+	Start, Length int
+}
+
+// OCRWord is a single recognized word.
+type OCRWord struct {
+	Text        string
+	Box         BoundingBox
+	Polygon     []Point
+	Confidence  float64 // 0..1 or ConfidenceUnknown
+	EntityIndex int     // Index into OCRResult.Entities, -1 for none
+	Segments    []TextSegment
+}
+
+// OCRLine is a line of recognized words.
+type OCRLine struct {
+	Text        string
+	Box         BoundingBox
+	Polygon     []Point
+	Orientation int     // Clockwise rotation of the line in degrees
+	Confidence  float64 // 0..1 or ConfidenceUnknown
+	Words       []OCRWord
+	Segments    []TextSegment
+}
+
+// OCRLanguage is a language detected in a block.
+type OCRLanguage struct {
+	Code       string
+	Confidence float64 // 0..1 or ConfidenceUnknown
+}
+
+// OCRBlock is a group of lines the engine considers one text block.
+type OCRBlock struct {
+	Box        BoundingBox
+	Polygon    []Point
+	LayoutType string // Engine-specific layout class, e.g. LAYOUT_TYPE_HEADER
+	Languages  []OCRLanguage
+	Confidence float64 // 0..1 or ConfidenceUnknown
+	Lines      []OCRLine
+	Segments   []TextSegment
+}
+
+// OCREntity is a named entity the engine extracted, such as a date.
+type OCREntity struct {
+	Name string
+	Text string
+}
+
+// OCRResult is the structured output of an OCR engine.
+type OCRResult struct {
+	Engine    string
+	FullText  string
+	Width     int
+	Height    int
+	Rotation  int      // Clockwise page rotation in degrees
+	Languages []string // Detected languages, most prominent first
+	Blocks    []OCRBlock
+	Entities  []OCREntity
+}
+
+// Text returns the full recognized text, rebuilding it from lines when the
+// engine did not provide one.
+func (r *OCRResult) Text() string {
+	if r.FullText != "" {
+		return strings.TrimSpace(r.FullText)
+	}
+	var sb strings.Builder
+	for _, block := range r.Blocks {
+		for _, line := range block.Lines {
+			sb.WriteString(line.Text)
+			sb.WriteString("\n")
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// OCREngine recognizes text in an image.
+type OCREngine interface {
+	Name() string
+	Recognize(image []byte, opts OCROptions) (*OCRResult, error)
+}
+
+// DocumentRecognizer is implemented by engines that accept multi-page
+// documents such as PDF and return one result per page.
+type DocumentRecognizer interface {
+	RecognizeDocument(document []byte, opts OCROptions) ([]*OCRResult, error)
+}
+
+var (
+	ocrEnginesMu sync.RWMutex
+	ocrEngines   = map[string]func() (OCREngine, error){
+		"yandex": newYandexOCREngine,
+	}
+)
+
+// RegisterOCREngine makes an engine available under name. The factory is
+// called each time the engine is requested so it can pick up fresh config.
+func RegisterOCREngine(name string, factory func() (OCREngine, error)) {
+	ocrEnginesMu.Lock()
+	defer ocrEnginesMu.Unlock()
+	ocrEngines[name] = factory
+}
+
+// OCREngineNames lists the registered engines in a stable order.
+func OCREngineNames() []string {
+	ocrEnginesMu.RLock()
+	defer ocrEnginesMu.RUnlock()
+	names := make([]string, 0, len(ocrEngines))
+	for name := range ocrEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultOCREngine returns the engine name configured with OCR_ENGINE.
+func DefaultOCREngine() string {
+	if name := os.Getenv("OCR_ENGINE"); name != "" {
+		return name
+	}
+	return "yandex"
+}
+
+// NewOCREngine builds the engine registered under name. An empty name
+// selects the configured default.
+func NewOCREngine(name string) (OCREngine, error) {
+	if name == "" {
+		name = DefaultOCREngine()
+	}
+	ocrEnginesMu.RLock()
+	factory, ok := ocrEngines[name]
+	ocrEnginesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown OCR engine %q", name)
+	}
+	return factory()
+}
@@ -1,77 +1,268 @@
-package main
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"time"
-)
-
-type TokenRequest struct {
-	YandexPassportOauthToken string `json:"yandexPassportOauthToken"`
-}
-
-type TokenResponse struct {
-	IamToken  string `json:"iamToken"`
-	ExpiresAt string `json:"expiresAt"`
-}
-
-func getIAMToken(oauthToken string) (string, error) {
-	url := "https://iam.api.cloud.yandex.net/iam/v1/tokens"
-
-	// Create request body
-	requestBody, err := json.Marshal(TokenRequest{
-		YandexPassportOauthToken: oauthToken,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	// Create HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
-	}
-
-	// Parse response
-	var tokenResp TokenResponse
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %v", err)
-	}
-
-	return tokenResp.IamToken, nil
-}
-
-func UpdateIAM() {
-	for {
-		iam, err := getIAMToken(os.Getenv("YANDEX_OAUTH"))
-		if err != nil {
-			UpdateIAM()
-		}
-		os.Setenv("IAM_TOKEN", iam)
-		time.Sleep(12 * time.Hour)
-	}
-}
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type TokenRequest struct {
+	YandexPassportOauthToken string `json:"yandexPassportOauthToken"`
+}
+
+type TokenResponse struct {
+	IamToken  string `json:"iamToken"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// ErrNoIAMToken is returned while no unexpired IAM token is available.
+var ErrNoIAMToken = errors.New("no valid IAM token")
+
+// TokenSource supplies bearer tokens for Yandex Cloud API calls.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// staticToken is a TokenSource for a fixed IAM_TOKEN from the environment.
+type staticToken string
+
+func (t staticToken) Token() (string, error) { return string(t), nil }
+
+// defaultIAMURL is the Yandex Cloud IAM token endpoint.
+const defaultIAMURL = "https://iam.api.cloud.yandex.net/iam/v1/tokens"
+
+// iamURL returns the IAM endpoint, overridable with YANDEX_IAM_URL so the
+// exchange can be pointed at a local stand-in.
+func iamURL() string {
+	if url := os.Getenv("YANDEX_IAM_URL"); url != "" {
+		return url
+	}
+	return defaultIAMURL
+}
+
+func getIAMToken(oauthToken string) (TokenResponse, error) {
+	return exchangeIAMToken(iamURL(), TokenRequest{
+		YandexPassportOauthToken: oauthToken,
+	})
+}
+
+// exchangeIAMToken posts a credential to the IAM endpoint at url.
+func exchangeIAMToken(url string, credential interface{}) (TokenResponse, error) {
+	// Create request body
+	requestBody, err := json.Marshal(credential)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		return TokenResponse{}, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse response
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return TokenResponse{}, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if tokenResp.IamToken == "" {
+		return TokenResponse{}, fmt.Errorf("empty IAM token in response")
+	}
+
+	return tokenResp, nil
+}
+
+// IAMHealth is a snapshot of the token provider state.
+type IAMHealth struct {
+	Healthy   bool          // A token is cached and not expired
+	Age       time.Duration // Time since the cached token was issued
+	ExpiresAt time.Time
+	Failures  int   // Consecutive failed refreshes
+	LastError error // Error of the last failed refresh, nil after success
+}
+
+// IAMTokenProvider caches an IAM token in memory and refreshes it before it
+// expires, retrying failed refreshes with exponential backoff.
+type IAMTokenProvider struct {
+	fetch func() (TokenResponse, error)
+
+	// MinBackoff and MaxBackoff bound the delay between failed refreshes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	refreshMu sync.Mutex // serialises fetches
+
+	mu        sync.RWMutex
+	token     string
+	fetchedAt time.Time
+	expiresAt time.Time
+	failures  int
+	lastErr   error
+}
+
+// NewIAMTokenProvider returns a provider that obtains tokens with fetch.
+func NewIAMTokenProvider(fetch func() (TokenResponse, error)) *IAMTokenProvider {
+	return &IAMTokenProvider{
+		fetch:      fetch,
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Minute,
+	}
+}
+
+// OAuthTokenFetcher exchanges a Yandex Passport OAuth token for IAM tokens.
+func OAuthTokenFetcher(oauthToken string) func() (TokenResponse, error) {
+	return func() (TokenResponse, error) {
+		return getIAMToken(oauthToken)
+	}
+}
+
+// Token implements TokenSource. It returns the cached token, fetching one
+// synchronously when none is valid.
+func (p *IAMTokenProvider) Token() (string, error) {
+	if token, ok := p.cached(); ok {
+		return token, nil
+	}
+
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	// Another caller may have refreshed while we waited for the lock.
+	if token, ok := p.cached(); ok {
+		return token, nil
+	}
+	if err := p.refreshLocked(); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoIAMToken, err)
+	}
+	if token, ok := p.cached(); ok {
+		return token, nil
+	}
+	return "", ErrNoIAMToken
+}
+
+func (p *IAMTokenProvider) cached() (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.token == "" || !time.Now().Before(p.expiresAt) {
+		return "", false
+	}
+	return p.token, true
+}
+
+// Refresh fetches a new token now.
+func (p *IAMTokenProvider) Refresh() error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	return p.refreshLocked()
+}
+
+// refreshLocked fetches a token; the caller must hold p.refreshMu.
+func (p *IAMTokenProvider) refreshLocked() error {
+	resp, err := p.fetch()
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failures++
+		p.lastErr = err
+		return err
+	}
+	expiresAt, perr := time.Parse(time.RFC3339Nano, resp.ExpiresAt)
+	if perr != nil {
+		expiresAt = now.Add(12 * time.Hour) // Maximum IAM token lifetime
+	}
+	p.token = resp.IamToken
+	p.fetchedAt = now
+	p.expiresAt = expiresAt
+	p.failures = 0
+	p.lastErr = nil
+	return nil
+}
+
+// Run keeps the token fresh until ctx is cancelled: it refreshes at half of
+// the token lifetime and backs off exponentially while refreshes fail.
+func (p *IAMTokenProvider) Run(ctx context.Context) {
+	backoff := p.MinBackoff
+	for {
+		var wait time.Duration
+		if err := p.Refresh(); err != nil {
+			h := p.Health()
+			log.Printf("IAM token refresh failed (attempt %d, retry in %s): %v", h.Failures, backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		} else {
+			backoff = p.MinBackoff
+			p.mu.RLock()
+			wait = p.expiresAt.Sub(p.fetchedAt) / 2
+			p.mu.RUnlock()
+			if wait < time.Minute {
+				wait = time.Minute
+			}
+			log.Printf("IAM token refreshed, next refresh in %s", wait.Round(time.Second))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Age returns how long ago the cached token was issued.
+func (p *IAMTokenProvider) Age() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.fetchedAt.IsZero() {
+		return 0
+	}
+	return time.Since(p.fetchedAt)
+}
+
+// Health reports the provider state.
+func (p *IAMTokenProvider) Health() IAMHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h := IAMHealth{
+		Healthy:   p.token != "" && time.Now().Before(p.expiresAt),
+		ExpiresAt: p.expiresAt,
+		Failures:  p.failures,
+		LastError: p.lastErr,
+	}
+	if !p.fetchedAt.IsZero() {
+		h.Age = time.Since(p.fetchedAt)
+	}
+	return h
+}
@@ -1,300 +1,588 @@
-package main
-
-import (
-	"bytes"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strings"
-
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/jung-kurt/gofpdf"
-)
-
-var userSettings = make(map[int64]*UserSettings)
-
-func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
-	if update.Message == nil {
-		return
-	}
-
-	msg := update.Message
-	chatID := msg.Chat.ID
-
-	if _, exists := userSettings[chatID]; !exists {
-		userSettings[chatID] = DefaultSettings()
-	}
-
-	s := userSettings[chatID]
-
-	switch {
-	case msg.IsCommand():
-		handleCommand(bot, msg)
-	case msg.Photo != nil:
-		handleImage(bot, msg)
-	case msg.Text != "":
-		if s.Stage != "" {
-			handleStageInput(bot, msg)
-		} else {
-			handleSettingsResponse(bot, msg)
-		}
-	default:
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "send_image")))
-	}
-}
-
-func handleCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	switch msg.Command() {
-	case "start":
-		msg := tgbotapi.NewMessage(chatID, tr(chatID, "start"))
-		labelHelp := "/help"
-		labelSettings := "/settings"
-		labelAbout := "/about"
-		if userSettings[chatID].Language == "Английский" {
-			labelHelp = "Help"
-			labelSettings = "Settings"
-			labelAbout = "About"
-		}
-		msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
-			tgbotapi.NewKeyboardButtonRow(
-				tgbotapi.NewKeyboardButton(labelHelp),
-				tgbotapi.NewKeyboardButton(labelSettings),
-				tgbotapi.NewKeyboardButton(labelAbout),
-			),
-		)
-		bot.Send(msg)
-	case "help":
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "help")))
-	case "settings":
-		showSettings(bot, msg)
-	case "about":
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "about")))
-	default:
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "unknown_command")))
-	}
-}
-
-func showSettings(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	settings := userSettings[chatID]
-
-	reply := tgbotapi.NewMessage(chatID, tr(chatID, "settings_menu")+
-		"\n1. "+tr(chatID, "language")+": "+settings.Language+
-		"\n2. "+tr(chatID, "format")+": "+settings.Format+
-		"\n3. "+tr(chatID, "model")+": "+settings.Model+
-		"\n\n"+tr(chatID, "settings_instruction"))
-	reply.ReplyMarkup = settingsKeyboard(settings.Language)
-	bot.Send(reply)
-}
-
-func handleStageInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	settings := userSettings[chatID]
-	text := msg.Text
-
-	switch settings.Stage {
-	case "language":
-		if text == "Русский" || text == "Russian" {
-			settings.Language = "Русский"
-		} else {
-			settings.Language = "Английский"
-		}
-		settings.Stage = ""
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "language_set")+": "+settings.Language))
-	case "format":
-		settings.Format = text
-		settings.Stage = ""
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "format_set")+": "+settings.Format))
-	case "model":
-		settings.Model = text
-		settings.Stage = ""
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "model_set")+": "+settings.Model))
-	}
-	showSettings(bot, msg)
-}
-
-func handleSettingsResponse(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	text := msg.Text
-	s := userSettings[chatID]
-
-	switch text {
-	case getLabel(s.Language, "change_lang"):
-		s.Stage = "language"
-		req := tgbotapi.NewMessage(chatID, tr(chatID, "language")+":")
-		req.ReplyMarkup = langKeyboard()
-		bot.Send(req)
-	case getLabel(s.Language, "change_format"):
-		s.Stage = "format"
-		req := tgbotapi.NewMessage(chatID, tr(chatID, "format")+":")
-		req.ReplyMarkup = formatKeyboard(s.Language)
-		bot.Send(req)
-	case getLabel(s.Language, "change_model"):
-		s.Stage = "model"
-		req := tgbotapi.NewMessage(chatID, tr(chatID, "model")+":")
-		req.ReplyMarkup = modelKeyboard(s.Language)
-		bot.Send(req)
-	default:
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "unknown_command")))
-	}
-}
-
-func tr(chatID int64, key string) string {
-	lang := userSettings[chatID].Language
-
-	rus := map[string]string{
-		"start":                "Привет! Я помогу тебе распознать рукописный текст. Отправь фото!",
-		"help":                 "Команды: /start, /help, /settings, /about",
-		"about":                "🤖 Я использую нейросеть для распознавания рукописного текста. Разработчик: Mikudayo Team",
-		"unknown_command":      "Неизвестная команда. Напиши /help.",
-		"send_image":           "Пожалуйста, отправь изображение с рукописным текстом.",
-		"settings_menu":        "⚙️ Настройки:",
-		"settings_instruction": "Выбери, что хочешь изменить:",
-		"language":             "Язык интерфейса",
-		"format":               "Формат ответа",
-		"model":                "Модель",
-		"language_set":         "Язык интерфейса изменён на",
-		"format_set":           "Формат ответа установлен",
-		"model_set":            "Выбрана модель",
-		"error_image":          "Не удалось получить изображение.",
-		"error_download":       "Ошибка загрузки изображения.",
-		"error_save":           "Ошибка сохранения изображения.",
-		"error_ocr":            "Ошибка при распознавании текста",
-		"error_config":         "Ошибка конфигурации: IAM_TOKEN или FOLDER_ID не установлены.",
-		"pdf_not_supported":    "PDF пока не поддерживается.",
-		"timing_header":        "Время выполнения:",
-		"ocr_time":             "OCR",
-		"gpt_time":             "DeepSeek",
-		"total_time":           "Общее",
-		"seconds":              "сек",
-		"ocr_result":           "Распознанный текст",
-		"gpt_result":           "Восстановленный текст",
-	}
-	en := map[string]string{
-		"start":                "Hello! I will help you recognize handwritten text. Just send a photo!",
-		"help":                 "Commands: /start, /help, /settings, /about",
-		"about":                "🤖 I use a neural net to recognize handwritten text. Developer: Mikudayo Team",
-		"unknown_command":      "Unknown command. Type /help.",
-		"send_image":           "Please send an image with handwritten text.",
-		"settings_menu":        "⚙️ Settings:",
-		"settings_instruction": "Choose what you'd like to change:",
-		"language":             "Interface language",
-		"format":               "Response format",
-		"model":                "Model",
-		"language_set":         "Language set to",
-		"format_set":           "Response format set to",
-		"model_set":            "Model set to",
-		"error_image":          "Failed to retrieve image.",
-		"error_download":       "Error downloading image.",
-		"error_save":           "Error saving image.",
-		"error_ocr":            "Error recognizing text",
-		"error_config":         "Configuration error: IAM_TOKEN or FOLDER_ID not set.",
-		"pdf_not_supported":    "PDF is not supported yet.",
-		"timing_header":        "Execution time:",
-		"ocr_time":             "OCR",
-		"gpt_time":             "DeepSeek",
-		"total_time":           "Total",
-		"seconds":              "sec",
-		"ocr_result":           "Recognized text",
-		"gpt_result":           "Restored text",
-	}
-
-	if lang == "Английский" {
-		return en[key]
-	}
-	return rus[key]
-}
-
-func handleImage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	photo := msg.Photo[len(msg.Photo)-1]
-	fileURL, err := bot.GetFileDirectURL(photo.FileID)
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_image")))
-		return
-	}
-
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_download")))
-		return
-	}
-	defer resp.Body.Close()
-
-	tmpPath := fmt.Sprintf("photo_%d.jpg", chatID)
-	out, err := os.Create(tmpPath)
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_save")))
-		return
-	}
-	defer os.Remove(tmpPath)
-	defer out.Close()
-	if _, err := io.Copy(out, resp.Body); err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_save")))
-		return
-	}
-
-	iamToken := os.Getenv("IAM_TOKEN")
-	folderID := os.Getenv("FOLDER_ID")
-	mistralAPIKey := os.Getenv("MISTRAL_API_KEY")
-	if iamToken == "" || folderID == "" || mistralAPIKey == "" {
-		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_config")))
-		return
-	}
-
-	ocrText, gptText, _, err := ProcessImage(tmpPath, folderID, iamToken, mistralAPIKey)
-	responseMsg := ""
-	if ocrText != "" {
-		// responseMsg += fmt.Sprintf("%s:\n%s\n\n", tr(chatID, "ocr_result"), ocrText)
-	}
-	if gptText != "" {
-		responseMsg += fmt.Sprintf("%s", gptText)
-		strings.ReplaceAll(responseMsg, "слишком неразборчиво 9905148", "Текст слишком неразборчивый, попробуйте сфотографировать получше и повторите попытку.")
-	}
-	if err != nil {
-		responseMsg += fmt.Sprintf("\n\n%s: %v", tr(chatID, "error_ocr"), err)
-	}
-
-	format := userSettings[chatID].Format
-	switch format {
-	case "TXT-файл":
-		if gptText != "" {
-			file := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
-				Name:  "result.txt",
-				Bytes: []byte(gptText),
-			})
-			bot.Send(file)
-		}
-	case "PDF-файл":
-		if gptText != "" {
-			// Создаем PDF
-			pdf := gofpdf.New("P", "mm", "A4", "")
-			pdf.AddPage()
-			pdf.AddUTF8Font("DejaVu", "", "DejaVuSans.ttf")
-			pdf.SetFont("DejaVu", "", 12)
-			pdf.MultiCell(190, 5, gptText, "", "", false)
-
-			// Конвертируем PDF в байты
-			var buf bytes.Buffer
-			err := pdf.Output(&buf)
-			if err != nil {
-				bot.Send(tgbotapi.NewMessage(chatID, "Ошибка при создании PDF"))
-				// return err
-			}
-
-			// Отправляем PDF
-			file := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
-				Name:  "result.pdf",
-				Bytes: buf.Bytes(),
-			})
-			_, err = bot.Send(file)
-			// return err
-		}
-	default:
-		if responseMsg != "" {
-			bot.Send(tgbotapi.NewMessage(chatID, responseMsg))
-		}
-	}
-}
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// jobQueue limits concurrent recognitions; main creates it from the environment.
+var jobQueue *JobQueue
+
+// albums collects media-group photos; main wires it to the session manager.
+var albums *AlbumCollector
+
+// recentResults keeps the latest transcripts for the "show changes" button.
+var recentResults = NewRecentResults(recentResultsSize)
+
+// dialogs runs multi-step conversations such as /settings.
+var dialogs = NewDialogs(settingsDialog)
+
+// settingsStore holds user settings; main replaces it with a file-backed store.
+var settingsStore SettingsStore = NewMemorySettingsStore()
+
+// updateSettings applies fn to the chat's settings, logging persistence errors.
+// fn runs under the store lock and must not call tr or the store itself.
+func updateSettings(chatID int64, fn func(s *UserSettings)) UserSettings {
+	s, err := settingsStore.Update(chatID, fn)
+	if err != nil {
+		log.Printf("save settings for %d: %v", chatID, err)
+	}
+	return s
+}
+
+func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		handleCallback(bot, update.CallbackQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+
+	msg := update.Message
+	chatID := msg.Chat.ID
+
+	switch {
+	case msg.IsCommand():
+		handleCommand(bot, chatID, msg.Command())
+	case msg.Photo != nil:
+		handleImage(bot, msg)
+	case msg.Document != nil:
+		handleDocument(bot, msg)
+	case msg.Text != "":
+		// Кнопки стартовой клавиатуры присылают текст без слеша
+		if command, ok := startCommand(msg.Text); ok {
+			handleCommand(bot, chatID, command)
+		} else if dialogs.Active(chatID) {
+			handleDialogInput(bot, chatID, 0, msg.Text)
+		} else {
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "unknown_command")))
+		}
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "send_image")))
+	}
+}
+
+// startCommands are the commands offered on the /start keyboard.
+var startCommands = []string{"help", "settings", "about"}
+
+// startCommand maps a /start keyboard label in any interface language back
+// to its command.
+func startCommand(label string) (string, bool) {
+	for _, lang := range Languages {
+		for _, command := range startCommands {
+			if translate(lang, "button_"+command) == label {
+				return command, true
+			}
+		}
+	}
+	return "", false
+}
+
+func handleCommand(bot *tgbotapi.BotAPI, chatID int64, command string) {
+	switch command {
+	case "start":
+		msg := tgbotapi.NewMessage(chatID, tr(chatID, "start"))
+		var row []tgbotapi.KeyboardButton
+		for _, command := range startCommands {
+			row = append(row, tgbotapi.NewKeyboardButton(tr(chatID, "button_"+command)))
+		}
+		msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(row)
+		bot.Send(msg)
+	case "help":
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "help")))
+	case "settings":
+		startDialog(bot, chatID, settingsDialog.Name)
+	case "cancel":
+		if dialogs.Cancel(chatID) {
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "dialog_cancelled")))
+		} else {
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "nothing_to_cancel")))
+		}
+	case "about":
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "about")))
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "unknown_command")))
+	}
+}
+
+// tr translates key into the chat's interface language; args are
+// placeholder name/value pairs as for translate.
+func tr(chatID int64, key string, args ...interface{}) string {
+	return translate(settingsStore.Get(chatID).Language, key, args...)
+}
+
+func handleImage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	photo := msg.Photo[len(msg.Photo)-1]
+
+	// Фото из альбома собираются вместе и распознаются одним заданием
+	if msg.MediaGroupID != "" {
+		albums.Add(msg.MediaGroupID, chatID, msg.MessageID, photo.FileID)
+		return
+	}
+
+	recognizeFile(bot, chatID, photo.FileID)
+}
+
+// maxDocumentSize is the largest file the Bot API lets bots download.
+const maxDocumentSize = 20 << 20
+
+// handleDocument accepts PDFs and images sent as files, which keep their
+// original resolution and give much better OCR on handwriting than
+// compressed photos.
+func handleDocument(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	doc := msg.Document
+	if doc.FileSize > maxDocumentSize {
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_file_too_big")))
+		return
+	}
+
+	if msg.MediaGroupID != "" {
+		albums.Add(msg.MediaGroupID, chatID, msg.MessageID, doc.FileID)
+		return
+	}
+	recognizeFile(bot, chatID, doc.FileID)
+}
+
+// recognizeFile downloads a single photo or document and queues it.
+func recognizeFile(bot *tgbotapi.BotAPI, chatID int64, fileID string) {
+	page, ok := downloadPage(bot, chatID, fileID)
+	if !ok {
+		return
+	}
+	submitRecognition(bot, chatID, []Page{page})
+}
+
+// handleAlbum recognizes the photos of a media group as pages of one document.
+func handleAlbum(bot *tgbotapi.BotAPI, chatID int64, fileIDs []string) {
+	pages := make([]Page, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		page, ok := downloadPage(bot, chatID, fileID)
+		if !ok {
+			return
+		}
+		pages = append(pages, page)
+	}
+	submitRecognition(bot, chatID, pages)
+}
+
+// downloadPage fetches a file and converts it into an OCR-ready page,
+// telling the user what went wrong otherwise.
+func downloadPage(bot *tgbotapi.BotAPI, chatID int64, fileID string) (Page, bool) {
+	data, err := downloadFile(bot, fileID)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, err.Error())))
+		return Page{}, false
+	}
+	page, err := preparePage(data)
+	if errors.Is(err, ErrUnsupportedImage) {
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "unsupported_format")))
+		return Page{}, false
+	}
+	if err != nil {
+		log.Printf("prepare page for %d: %v", chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_image")))
+		return Page{}, false
+	}
+	return page, true
+}
+
+// downloadFile fetches a Telegram file. Errors carry the tr key to show.
+func downloadFile(bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		log.Printf("get file %s: %v", fileID, err)
+		return nil, errors.New("error_image")
+	}
+
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		log.Printf("download file %s: %v", fileID, err)
+		return nil, errors.New("error_download")
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("read file %s: %v", fileID, err)
+		return nil, errors.New("error_download")
+	}
+	return data, nil
+}
+
+// submitRecognition queues the pages for recognition with the chat's
+// current settings and tells the user where they are in line.
+func submitRecognition(bot *tgbotapi.BotAPI, chatID int64, pages []Page) {
+	settings := settingsStore.Get(chatID)
+	// Бэкенды создаются в задании, чтобы не занимать сессию чата
+	position, err := jobQueue.Submit(chatID, func() {
+		recognizePages(bot, chatID, pages, settings)
+	})
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "queue_full")))
+	case errors.Is(err, ErrUserQueueLimit):
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "queue_user_limit")))
+	case position > 0:
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "queue_position", "position", position)))
+	}
+}
+
+// recognizePages runs the pipeline on a queue worker, page by page in order,
+// and replies with one combined result in the user's chosen format.
+func recognizePages(bot *tgbotapi.BotAPI, chatID int64, pages []Page, settings UserSettings) {
+	profile := LoadProfile(settings.Model)
+	engine, err := NewOCREngine(settings.Engine)
+	// Без исправления ключи LLM не нужны
+	var corrector Corrector = noopCorrector{}
+	if err == nil && profile.Correct {
+		corrector, err = NewCorrector()
+	}
+	if errors.Is(err, ErrNotConfigured) {
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_config")))
+		return
+	}
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), err)))
+		return
+	}
+
+	var results []*Result
+	var errs []error
+	for _, page := range pages {
+		opts := OCROptions{MimeType: page.MimeType, LanguageCodes: docLanguageCodes(settings.DocLang)}
+		if page.MimeType == "application/pdf" {
+			docResults, docErrs := ProcessDocument(engine, corrector, page.Image, opts, profile)
+			results = append(results, docResults...)
+			errs = append(errs, docErrs...)
+			continue
+		}
+		result, err := ProcessImage(engine, corrector, page.Image, opts, profile)
+		results = append(results, result)
+		errs = append(errs, err)
+	}
+
+	gptText := joinPages(chatID, results, errs, 0)
+	errorNote := ""
+	if len(results) == 1 && errs[0] != nil {
+		errorNote = fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[0])
+	}
+	profileInfo := profileSummary(chatID, results[0])
+
+	// Кнопка «Показать изменения», если модель что-то исправила
+	var changesID string
+	var markup interface{}
+	if changed(results, errs) {
+		changesID = recentResults.Put(chatID, results, errs)
+		if !settings.ShowDiff {
+			markup = changesKeyboard(chatID, changesID)
+		}
+	}
+
+	// sendFile attaches a result file with the profile caption and the changes button
+	sendFile := func(name string, data []byte) {
+		file := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+		file.Caption = profileInfo
+		file.ReplyMarkup = markup
+		send(bot, chatID, file)
+	}
+
+	format := settings.Format
+	if gptText == "" && format != "Структурированный текст" {
+		// Прикладывать нечего: отвечаем сообщением с причиной вместо пустого файла
+		format = "Простой текст"
+	}
+	switch format {
+	case "TXT-файл":
+		sendFile("result.txt", []byte(gptText))
+	case "PDF-файл", "PDF-скан":
+		build := buildPDF
+		if format == "PDF-скан" {
+			build = buildSearchablePDF
+		}
+		pdfBytes, err := build(chatID, results, errs, settings.Highlight)
+		if err != nil {
+			log.Printf("build PDF for %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_pdf")))
+			return
+		}
+		sendFile("result.pdf", pdfBytes)
+	case "DOCX-файл":
+		docxBytes, err := buildDOCX(chatID, results, errs, settings.Highlight)
+		if err != nil {
+			log.Printf("build DOCX for %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_docx")))
+			return
+		}
+		sendFile("result.docx", docxBytes)
+	case "MD-файл":
+		sendFile("result.md", []byte(renderMarkdown(chatID, results, errs)))
+	case "HTML-файл":
+		sendFile("result.html", []byte(renderHTML(chatID, results, errs)))
+	case "hOCR", "ALTO XML", "PAGE XML", "JSON":
+		var name string
+		var data []byte
+		var err error
+		switch format {
+		case "hOCR":
+			name, data = "result.hocr", buildHOCR(results, errs)
+		case "ALTO XML":
+			name, data = "result.alto.xml", buildALTO(results, errs)
+		case "PAGE XML":
+			name, data, err = buildPAGEXML(results, errs)
+		default:
+			name = "result.json"
+			data, err = buildJSON(results, errs)
+		}
+		if err != nil {
+			log.Printf("build %s for %d: %v", format, chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "error_export")))
+			return
+		}
+		sendFile(name, data)
+	case "Структурированный текст":
+		// Заголовки и списки, восстановленные по разметке OCR, в HTML-разметке Telegram.
+		// Ошибки страниц renderTelegramHTML пишет сам, errorNote не нужен
+		text := joinWithNotes(renderTelegramHTML(chatID, results, errs), "", html.EscapeString(profileInfo))
+		sendText(bot, chatID, text, tgbotapi.ModeHTML, markup)
+	default:
+		text, parseMode := joinWithNotes(gptText, errorNote, profileInfo), ""
+		if settings.Highlight > 0 {
+			// Подчёркиваем сомнительные слова, остальной текст экранируем для HTML
+			text = joinWithNotes(joinPages(chatID, results, errs, settings.Highlight),
+				html.EscapeString(errorNote), html.EscapeString(profileInfo))
+			parseMode = tgbotapi.ModeHTML
+		}
+		sendText(bot, chatID, text, parseMode, markup)
+	}
+
+	if settings.ShowDiff && changesID != "" {
+		sendChanges(bot, chatID, changesID)
+	}
+
+	if warning := legibilityWarning(chatID, results, errs); warning != "" {
+		bot.Send(tgbotapi.NewMessage(chatID, warning))
+	}
+}
+
+func changesKeyboard(chatID int64, id string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(chatID, "show_changes"), "diff:"+id),
+	))
+}
+
+// handleCallback handles inline button presses.
+func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+	notice := ""
+	if query.Message != nil {
+		chatID := query.Message.Chat.ID
+		if id, ok := strings.CutPrefix(query.Data, "diff:"); ok {
+			sendChanges(bot, chatID, id)
+		} else if input, ok := strings.CutPrefix(query.Data, "dialog:"); ok {
+			notice = handleDialogInput(bot, chatID, query.Message.MessageID, input)
+		}
+	}
+
+	// Telegram показывает часики на кнопке, пока не получит ответ
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, notice)); err != nil {
+		log.Printf("answer callback: %v", err)
+	}
+}
+
+// startDialog starts a dialog and sends its first step.
+func startDialog(bot *tgbotapi.BotAPI, chatID int64, name string) {
+	view, err := dialogs.Start(chatID, name)
+	if err != nil {
+		log.Printf("start dialog %s for %d: %v", name, chatID, err)
+		return
+	}
+	sendDialogView(bot, chatID, 0, view)
+}
+
+// handleDialogInput feeds a typed answer or a pressed choice to the chat's
+// dialog. Choices edit the dialog message in place (messageID), typed
+// answers get a new message. It returns the dialog's notice for the
+// callback answer; typed answers get it as a message.
+func handleDialogInput(bot *tgbotapi.BotAPI, chatID int64, messageID int, input string) string {
+	view, err := dialogs.Handle(chatID, input)
+	switch {
+	case errors.Is(err, ErrNoDialog), errors.Is(err, ErrDialogExpired):
+		view = DialogView{Text: tr(chatID, "dialog_expired"), Done: true}
+	case err != nil:
+		log.Printf("dialog input for %d: %v", chatID, err)
+		view = DialogView{Text: tr(chatID, "dialog_expired"), Done: true}
+	}
+
+	if messageID == 0 && view.Notice != "" {
+		bot.Send(tgbotapi.NewMessage(chatID, view.Notice))
+	}
+	sendDialogView(bot, chatID, messageID, view)
+	return view.Notice
+}
+
+// sendDialogView shows a dialog step with its choices as inline buttons,
+// editing messageID when it is set.
+func sendDialogView(bot *tgbotapi.BotAPI, chatID int64, messageID int, view DialogView) {
+	var markup *tgbotapi.InlineKeyboardMarkup
+	if !view.Done && len(view.Choices) > 0 {
+		rows := make([][]tgbotapi.InlineKeyboardButton, len(view.Choices))
+		for i, choices := range view.Choices {
+			for _, choice := range choices {
+				rows[i] = append(rows[i], tgbotapi.NewInlineKeyboardButtonData(choice.Label, "dialog:"+choice.Input))
+			}
+		}
+		m := tgbotapi.NewInlineKeyboardMarkup(rows...)
+		markup = &m
+	}
+
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, view.Text)
+		if markup != nil {
+			msg.ReplyMarkup = *markup
+		}
+		bot.Send(msg)
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, view.Text)
+	edit.ReplyMarkup = markup
+	if _, err := bot.Request(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("edit dialog message for %d: %v", chatID, err)
+	}
+}
+
+// sendChanges shows what the corrector changed in a recent transcript.
+func sendChanges(bot *tgbotapi.BotAPI, chatID int64, id string) {
+	pages, ok := recentResults.Get(chatID, id)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, tr(chatID, "changes_expired")))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(html.EscapeString(tr(chatID, "changes_header")))
+	for i, page := range pages {
+		sb.WriteString("\n\n")
+		if len(pages) > 1 {
+			sb.WriteString(html.EscapeString(pageHeader(chatID, i+1, len(pages))) + "\n")
+		}
+		switch {
+		case page.Failed:
+			sb.WriteString(html.EscapeString(tr(chatID, "error_ocr")))
+		case page.OCRText == page.Text:
+			sb.WriteString(html.EscapeString(tr(chatID, "no_changes")))
+		default:
+			sb.WriteString(diffHTML(page.OCRText, page.Text))
+		}
+	}
+
+	sendText(bot, chatID, sb.String(), tgbotapi.ModeHTML, nil)
+}
+
+// joinWithNotes appends the error note and the profile summary to the
+// transcript, each as its own paragraph.
+func joinWithNotes(text, errorNote, profileInfo string) string {
+	if errorNote != "" {
+		text += "\n\n" + errorNote
+	}
+	return text + "\n\n" + profileInfo
+}
+
+// maxUncertainShown limits how many doubtful fragments the warning lists.
+const maxUncertainShown = 10
+
+// legibilityWarning explains which pages were hard to read and how to
+// reshoot them, or returns "" when every page was legible.
+func legibilityWarning(chatID int64, results []*Result, errs []error) string {
+	var pages []string
+	var uncertain []string
+	for i, result := range results {
+		if errs[i] != nil || result.Legible {
+			continue
+		}
+		pages = append(pages, fmt.Sprint(i+1))
+		uncertain = append(uncertain, result.Uncertain...)
+	}
+	if len(pages) == 0 {
+		return ""
+	}
+
+	warning := "⚠️ " + tr(chatID, "illegible")
+	if len(results) > 1 {
+		warning += "\n" + tr(chatID, "illegible_pages",
+			"count", len(pages), "total", len(results), "pages", strings.Join(pages, ", "))
+	}
+	if len(uncertain) > 0 {
+		if len(uncertain) > maxUncertainShown {
+			uncertain = uncertain[:maxUncertainShown]
+		}
+		warning += "\n\n" + tr(chatID, "uncertain_fragments") + ": «" + strings.Join(uncertain, "», «") + "»"
+	}
+	return warning + "\n\n" + tr(chatID, "reshoot_tips")
+}
+
+// joinPages combines page texts into one transcript. Multi-page results get
+// a header per page and an inline note for pages that failed. With a
+// positive highlight threshold the transcript is HTML with doubtful words
+// underlined.
+func joinPages(chatID int64, results []*Result, errs []error, highlight float64) string {
+	page := func(result *Result) string { return result.Text }
+	escape := func(s string) string { return s }
+	if highlight > 0 {
+		page = func(result *Result) string { return highlightHTML(result, highlight) }
+		escape = html.EscapeString
+	}
+
+	if len(results) == 1 {
+		return page(results[0])
+	}
+	var sb strings.Builder
+	for i, result := range results {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(escape(pageHeader(chatID, i+1, len(results))))
+		sb.WriteString("\n")
+		if errs[i] != nil {
+			sb.WriteString(escape(fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[i])))
+			continue
+		}
+		sb.WriteString(page(result))
+	}
+	return sb.String()
+}
+
+func pageHeader(chatID int64, page, total int) string {
+	return "— " + tr(chatID, "page_header", "page", page, "total", total) + " —"
+}
+
+// engineName returns the effective OCR engine for a user setting.
+func engineName(name string) string {
+	if name == "" {
+		return DefaultOCREngine()
+	}
+	return name
+}
+
+// profileSummary describes which profile and models produced a result.
+func profileSummary(chatID int64, result *Result) string {
+	p := result.Profile
+	summary := fmt.Sprintf("⚙️ %s: %s · OCR %s", tr(chatID, "profile_info"),
+		tr(chatID, "model_"+p.Name), p.OCRModel)
+	if p.Correct {
+		llm := p.LLMModel
+		if llm == "" {
+			llm = "default"
+		}
+		summary += " · LLM " + llm
+	}
+	return summary
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// wordPattern splits texts into words for diffing and highlighting.
+var wordPattern = regexp.MustCompile(`\S+`)
+
+// textSpan is a piece of a text, marked when it should be highlighted.
+type textSpan struct {
+	Text   string
+	Marked bool
+}
+
+// ocrWords returns the recognized words in reading order together with
+// their confidence, falling back to the plain OCR text when the engine gave
+// no word structure.
+func ocrWords(result *Result) (words []string, confidence []float64) {
+	if result.OCR != nil {
+		for _, block := range result.OCR.Blocks {
+			for _, line := range block.Lines {
+				for _, word := range line.Words {
+					for _, w := range strings.Fields(word.Text) {
+						words = append(words, w)
+						confidence = append(confidence, word.Confidence)
+					}
+				}
+			}
+		}
+	}
+	if len(words) == 0 {
+		words = strings.Fields(result.OCRText)
+		confidence = make([]float64, len(words))
+		for i := range confidence {
+			confidence[i] = ConfidenceUnknown
+		}
+	}
+	return words, confidence
+}
+
+// highlightSpans splits result.Text into spans and marks the words that are
+// likely wrong: words the corrector changed or added, words the OCR engine
+// recognized with confidence below threshold, and fragments the corrector
+// reported as uncertain. A threshold of 0 disables highlighting.
+func highlightSpans(result *Result, threshold float64) []textSpan {
+	text := result.Text
+	if threshold <= 0 || text == "" {
+		return []textSpan{{Text: text}}
+	}
+
+	locs := wordPattern.FindAllStringIndex(text, -1)
+	words := make([]string, len(locs))
+	for i, loc := range locs {
+		words[i] = text[loc[0]:loc[1]]
+	}
+	marked := make([]bool, len(words))
+
+	ocr, confidence := ocrWords(result)
+	for _, edit := range diffWords(ocr, words) {
+		switch edit.Op {
+		case DiffInsert:
+			marked[edit.B] = true
+		case DiffEqual:
+			if c := confidence[edit.A]; c >= 0 && c < threshold {
+				marked[edit.B] = true
+			}
+		}
+	}
+	markFragments(words, marked, result.Uncertain)
+
+	var spans []textSpan
+	prev := 0
+	for i, loc := range locs {
+		if loc[0] > prev {
+			spans = append(spans, textSpan{Text: text[prev:loc[0]]})
+		}
+		spans = append(spans, textSpan{Text: words[i], Marked: marked[i]})
+		prev = loc[1]
+	}
+	if prev < len(text) {
+		spans = append(spans, textSpan{Text: text[prev:]})
+	}
+	return spans
+}
+
+// markFragments marks every occurrence of the fragments in words, ignoring
+// case and surrounding punctuation.
+func markFragments(words []string, marked []bool, fragments []string) {
+	norm := make([]string, len(words))
+	for i, w := range words {
+		norm[i] = normalizeWord(w)
+	}
+	for _, fragment := range fragments {
+		var needle []string
+		for _, w := range strings.Fields(fragment) {
+			if w = normalizeWord(w); w != "" {
+				needle = append(needle, w)
+			}
+		}
+		if len(needle) == 0 {
+			continue
+		}
+	search:
+		for i := 0; i+len(needle) <= len(norm); i++ {
+			for j, w := range needle {
+				if norm[i+j] != w {
+					continue search
+				}
+			}
+			for j := range needle {
+				marked[i+j] = true
+			}
+		}
+	}
+}
+
+func normalizeWord(w string) string {
+	return strings.ToLower(strings.TrimFunc(w, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// highlightHTML renders the result text for Telegram's HTML parse mode with
+// doubtful words underlined.
+func highlightHTML(result *Result, threshold float64) string {
+	var sb strings.Builder
+	for _, span := range highlightSpans(result, threshold) {
+		if span.Marked {
+			sb.WriteString("<u>" + html.EscapeString(span.Text) + "</u>")
+		} else {
+			sb.WriteString(html.EscapeString(span.Text))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// localeFiles holds one message catalog per interface language. A message is
+// either a string or an object of CLDR plural forms ("one", "few", "many",
+// "other") chosen by the {count} parameter. Both may contain {name}
+// placeholders.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// defaultLanguage is the language of new users and the last fallback.
+const defaultLanguage = "ru"
+
+// Languages lists the interface languages in menu order.
+var Languages = []string{"ru", "en", "uk", "kk", "de"}
+
+// message is a catalog entry.
+type message struct {
+	Text   string
+	Plural map[string]string
+}
+
+// UnmarshalJSON accepts a plain string or an object of plural forms.
+func (m *message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &m.Plural)
+}
+
+var catalogs = mustLoadCatalogs()
+
+// mustLoadCatalogs parses the embedded locale files; they are part of the
+// binary, so a broken file is a programming error.
+func mustLoadCatalogs() map[string]map[string]message {
+	catalogs := make(map[string]map[string]message)
+	for _, lang := range Languages {
+		data, err := localeFiles.ReadFile(path.Join("locales", lang+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("read locale %s: %v", lang, err))
+		}
+		var catalog map[string]message
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("parse locale %s: %v", lang, err))
+		}
+		catalogs[lang] = catalog
+	}
+	return catalogs
+}
+
+// checkCatalogs reports keys missing from some locales and plural messages
+// lacking a form their language needs. main logs the result at startup.
+func checkCatalogs() []string {
+	keys := make(map[string]bool)
+	for _, catalog := range catalogs {
+		for key := range catalog {
+			keys[key] = true
+		}
+	}
+
+	var problems []string
+	for _, lang := range Languages {
+		for key := range keys {
+			msg, ok := catalogs[lang][key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("locale %s: missing %q", lang, key))
+				continue
+			}
+			if msg.Plural == nil {
+				continue
+			}
+			for _, form := range pluralForms(lang) {
+				if _, ok := msg.Plural[form]; !ok {
+					problems = append(problems, fmt.Sprintf("locale %s: %q has no %q form", lang, key, form))
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// languageFallbacks returns the catalogs to try for lang: the language
+// itself, its base language for regional codes such as "de-AT", English and
+// the default language.
+func languageFallbacks(lang string) []string {
+	chain := []string{lang}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		chain = append(chain, base)
+	}
+	return append(chain, "en", defaultLanguage)
+}
+
+// translate returns the message for key in lang. args are name/value pairs
+// substituted for {name} placeholders; "count" also selects the plural form.
+// A key missing from every catalog is logged and returned as is.
+func translate(lang, key string, args ...interface{}) string {
+	params := make(map[string]string, len(args)/2)
+	count := -1
+	for i := 0; i+1 < len(args); i += 2 {
+		name := fmt.Sprint(args[i])
+		params[name] = fmt.Sprint(args[i+1])
+		if n, ok := args[i+1].(int); ok && name == "count" {
+			count = n
+		}
+	}
+
+	for _, l := range languageFallbacks(lang) {
+		msg, ok := catalogs[l][key]
+		if !ok {
+			continue
+		}
+		text := msg.Text
+		if msg.Plural != nil {
+			text = pluralText(l, msg.Plural, count)
+		}
+		for name, value := range params {
+			text = strings.ReplaceAll(text, "{"+name+"}", value)
+		}
+		return text
+	}
+	log.Printf("missing translation %q for %s", key, lang)
+	return key
+}
+
+// pluralForms lists the CLDR plural categories a language uses for integers.
+func pluralForms(lang string) []string {
+	switch lang {
+	case "ru", "uk":
+		return []string{"one", "few", "many"}
+	default:
+		return []string{"one", "other"}
+	}
+}
+
+// pluralText picks the plural form for n following the CLDR rules for
+// integers, falling back to "other" and then "many".
+func pluralText(lang string, forms map[string]string, n int) string {
+	form := "other"
+	switch lang {
+	case "ru", "uk":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			form = "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			form = "few"
+		default:
+			form = "many"
+		}
+	default:
+		if n == 1 {
+			form = "one"
+		}
+	}
+	for _, f := range []string{form, "other", "many"} {
+		if text, ok := forms[f]; ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// isLanguage reports whether lang has a catalog.
+func isLanguage(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestCatalogsComplete(t *testing.T) {
+	for _, problem := range checkCatalogs() {
+		t.Error(problem)
+	}
+}
+
+func TestPluralText(t *testing.T) {
+	forms := map[string]string{"one": "one", "few": "few", "many": "many", "other": "other"}
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"ru", 1, "one"},
+		{"ru", 2, "few"},
+		{"ru", 5, "many"},
+		{"ru", 11, "many"},
+		{"ru", 21, "one"},
+		{"uk", 1, "one"},
+		{"uk", 2, "few"},
+		{"uk", 5, "many"},
+		{"uk", 11, "many"},
+		{"uk", 21, "one"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"en", 21, "other"},
+	}
+	for _, tt := range tests {
+		if got := pluralText(tt.lang, forms, tt.n); got != tt.want {
+			t.Errorf("pluralText(%s, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTranslatePlural(t *testing.T) {
+	got := translate("ru", "illegible_pages", "count", 2, "total", 5, "pages", "1, 3")
+	want := "Плохо читаются 2 страницы из 5: 1, 3."
+	if got != want {
+		t.Errorf("translate = %q, want %q", got, want)
+	}
+}
+
+func TestTranslateFallbackOrder(t *testing.T) {
+	saved := catalogs
+	t.Cleanup(func() { catalogs = saved })
+	catalogs = map[string]map[string]message{
+		"pt-BR": {"a": {Text: "pt-BR"}},
+		"pt":    {"a": {Text: "pt"}, "b": {Text: "pt"}},
+		"en":    {"a": {Text: "en"}, "b": {Text: "en"}, "c": {Text: "en"}},
+		"ru":    {"a": {Text: "ru"}, "b": {Text: "ru"}, "c": {Text: "ru"}, "d": {Text: "ru {name}"}},
+	}
+
+	tests := []struct{ lang, key, want string }{
+		{"pt-BR", "a", "pt-BR"},
+		{"pt-BR", "b", "pt"},
+		{"pt-BR", "c", "en"},
+		{"pt-BR", "d", "ru x"},
+		{"pt-BR", "e", "e"},
+		{"de", "c", "en"},
+	}
+	for _, tt := range tests {
+		if got := translate(tt.lang, tt.key, "name", "x"); got != tt.want {
+			t.Errorf("translate(%s, %s) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	for _, f := range settingsFields {
+		if f.Key != "format" {
+			continue
+		}
+		for _, format := range f.Values() {
+			if _, ok := formatKeys[format]; !ok {
+				t.Errorf("format %q has no tr key", format)
+			}
+		}
+	}
+	if got := formatLabel("en", "PDF-скан"); got != "PDF scan" {
+		t.Errorf("formatLabel(en, PDF-скан) = %q", got)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrUnsupportedImage is returned for files that are not images the bot can
+// decode or pass through to OCR.
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ocrMimeTypes are accepted by the OCR API as is.
+var ocrMimeTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"application/pdf": true,
+}
+
+// detectMimeType sniffs the file type from its content rather than trusting
+// the extension or the MIME type reported by the client.
+func detectMimeType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		}
+	}
+	mimeType := http.DetectContentType(data)
+	if i := bytes.IndexByte([]byte(mimeType), ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType
+}
+
+// preparePage detects the file format and converts images the OCR API does
+// not accept into JPEG. PDF documents are passed through unchanged.
+func preparePage(data []byte) (Page, error) {
+	mimeType := detectMimeType(data)
+	if ocrMimeTypes[mimeType] {
+		return Page{Image: data, MimeType: mimeType}, nil
+	}
+
+	if mimeType == "image/heic" || mimeType == "image/avif" {
+		converted, err := convertHEIF(data, mimeType)
+		if err != nil {
+			return Page{}, err
+		}
+		return Page{Image: converted, MimeType: "image/jpeg"}, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Page{}, fmt.Errorf("%w: %s", ErrUnsupportedImage, mimeType)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
+		return Page{}, fmt.Errorf("convert %s to JPEG: %v", mimeType, err)
+	}
+	return Page{Image: buf.Bytes(), MimeType: "image/jpeg"}, nil
+}
+
+// heifConverter is the libheif command-line tool that converts HEIC and AVIF,
+// which Go cannot decode. HEIF_CONVERTER overrides its name or path.
+func heifConverter() string {
+	if tool := os.Getenv("HEIF_CONVERTER"); tool != "" {
+		return tool
+	}
+	return "heif-convert"
+}
+
+// convertHEIF converts a HEIC or AVIF image into JPEG with heifConverter.
+// Without the tool the format is reported as unsupported.
+func convertHEIF(data []byte, mimeType string) ([]byte, error) {
+	tool, err := exec.LookPath(heifConverter())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s, %s not installed", ErrUnsupportedImage, mimeType, heifConverter())
+	}
+
+	dir, err := os.MkdirTemp("", "heif")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in."+map[string]string{"image/heic": "heic", "image/avif": "avif"}[mimeType])
+	out := filepath.Join(dir, "out.jpg")
+	if err := os.WriteFile(in, data, 0o600); err != nil {
+		return nil, fmt.Errorf("write %s: %v", in, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if output, err := exec.CommandContext(ctx, tool, "-q", "92", in, out).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("convert %s to JPEG: %v: %s", mimeType, err, bytes.TrimSpace(output))
+	}
+
+	// Из файла с несколькими изображениями heif-convert пишет out-1.jpg, out-2.jpg…
+	if _, err := os.Stat(out); err != nil {
+		if matches, _ := filepath.Glob(filepath.Join(dir, "out-*.jpg")); len(matches) > 0 {
+			out = matches[0]
+		}
+	}
+	converted, err := os.ReadFile(out)
+	if err != nil {
+		return nil, fmt.Errorf("read converted %s: %v", mimeType, err)
+	}
+	return converted, nil
+}
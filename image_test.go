@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// heicHeader is the start of an ISO BMFF file with the heic brand.
+var heicHeader = []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+
+func TestDetectMimeType(t *testing.T) {
+	tests := map[string][]byte{
+		"image/heic": heicHeader,
+		"image/avif": []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"),
+		"image/tiff": []byte("II*\x00\x08\x00\x00\x00"),
+		"image/png":  []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+	}
+	for want, data := range tests {
+		if got := detectMimeType(data); got != want {
+			t.Errorf("detectMimeType = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPreparePageHEIFWithoutConverter(t *testing.T) {
+	t.Setenv("HEIF_CONVERTER", filepath.Join(t.TempDir(), "missing"))
+	if _, err := preparePage(heicHeader); !errors.Is(err, ErrUnsupportedImage) {
+		t.Fatalf("err = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestPreparePageHEIFConverts(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	fixture := filepath.Join(dir, "fixture.jpg")
+	if err := os.WriteFile(fixture, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Поддельный heif-convert: аргументы -q 92 <in> <out>
+	tool := filepath.Join(dir, "heif-convert")
+	script := "#!/bin/sh\ncp '" + fixture + "' \"$4\"\n"
+	if err := os.WriteFile(tool, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HEIF_CONVERTER", tool)
+
+	page, err := preparePage(heicHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.MimeType != "image/jpeg" || !bytes.Equal(page.Image, buf.Bytes()) {
+		t.Fatalf("page = %s, %d bytes", page.MimeType, len(page.Image))
+	}
+}
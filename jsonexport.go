@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSON export schema.
+//
+// The document is an ExportDocument. Schema is always "tgbogopd.result" and
+// Version is exportSchemaVersion; the version grows on any change that can
+// break a reader, such as a renamed or retyped field, while new optional
+// fields keep it. Coordinates are pixels of the recognized image with the
+// origin at the top left, confidences are 0..1 or null when the engine did
+// not report one, durations are seconds.
+const (
+	exportSchemaName    = "tgbogopd.result"
+	exportSchemaVersion = 1
+)
+
+// ExportDocument is the root of the JSON export.
+type ExportDocument struct {
+	Schema  string       `json:"schema"`
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"` // RFC 3339
+	Pages   []ExportPage `json:"pages"`   // In document order
+}
+
+// ExportPage is one recognized page. A failed page has Error set, empty
+// texts and none of the optional fields.
+type ExportPage struct {
+	Number    int            `json:"number"` // 1-based
+	Error     string         `json:"error,omitempty"`
+	Engine    string         `json:"engine,omitempty"`
+	Width     int            `json:"width,omitempty"`
+	Height    int            `json:"height,omitempty"`
+	Rotation  int            `json:"rotation,omitempty"`  // Clockwise, degrees
+	Languages []string       `json:"languages,omitempty"` // Detected, most prominent first
+	OCRText   string         `json:"ocr_text"`            // Raw recognized text
+	Text      string         `json:"text"`                // Corrected text, equal to ocr_text without correction
+	Legible   bool           `json:"legible"`
+	Uncertain []string       `json:"uncertain,omitempty"` // Fragments of text the corrector is unsure about
+	Profile   *ExportProfile `json:"profile,omitempty"`
+	Timing    *ExportTiming  `json:"timing,omitempty"`
+	Entities  []ExportEntity `json:"entities,omitempty"`
+	Blocks    []ExportBlock  `json:"blocks,omitempty"`
+}
+
+// ExportProfile is the pipeline profile and the models the page ran with.
+type ExportProfile struct {
+	Name        string  `json:"name"`
+	OCRModel    string  `json:"ocr_model"`
+	LLMModel    string  `json:"llm_model,omitempty"` // Empty for the corrector default
+	Temperature float64 `json:"temperature"`
+	Correct     bool    `json:"correct"`
+	OCRTimeout  float64 `json:"ocr_timeout"`
+	LLMTimeout  float64 `json:"llm_timeout"`
+}
+
+// ExportTiming is the time spent on each pipeline step.
+type ExportTiming struct {
+	OCR        float64 `json:"ocr"`
+	Correction float64 `json:"correction"`
+	Total      float64 `json:"total"`
+}
+
+// ExportEntity is a named entity the engine extracted, such as a date.
+type ExportEntity struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// ExportBox is an axis-aligned rectangle.
+type ExportBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ExportPoint is a polygon vertex.
+type ExportPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// ExportLanguage is a language detected in a block.
+type ExportLanguage struct {
+	Code       string   `json:"code"`
+	Confidence *float64 `json:"confidence"`
+}
+
+// ExportBlock is a text block of the page.
+type ExportBlock struct {
+	Box        ExportBox        `json:"box"`
+	Polygon    []ExportPoint    `json:"polygon,omitempty"`
+	LayoutType string           `json:"layout_type,omitempty"` // Engine-specific layout class
+	Languages  []ExportLanguage `json:"languages,omitempty"`
+	Confidence *float64         `json:"confidence"`
+	Lines      []ExportLine     `json:"lines"`
+}
+
+// ExportLine is a line of a block.
+type ExportLine struct {
+	Text        string        `json:"text"`
+	Box         ExportBox     `json:"box"`
+	Polygon     []ExportPoint `json:"polygon,omitempty"`
+	Orientation int           `json:"orientation,omitempty"` // Clockwise, degrees
+	Confidence  *float64      `json:"confidence"`
+	Words       []ExportWord  `json:"words"`
+}
+
+// ExportWord is a recognized word. Corrected is the corrected text aligned
+// to the word: "" when the corrector dropped it, several words when it
+// inserted some after it.
+type ExportWord struct {
+	Text       string        `json:"text"`
+	Corrected  string        `json:"corrected"`
+	Box        ExportBox     `json:"box"`
+	Polygon    []ExportPoint `json:"polygon,omitempty"`
+	Confidence *float64      `json:"confidence"`
+	Entity     *int          `json:"entity,omitempty"` // Index into the page entities
+}
+
+// buildJSON renders the results in the JSON export schema.
+func buildJSON(results []*Result, errs []error) ([]byte, error) {
+	doc := ExportDocument{
+		Schema:  exportSchemaName,
+		Version: exportSchemaVersion,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Pages:   make([]ExportPage, len(results)),
+	}
+	for i, result := range results {
+		if errs[i] != nil {
+			doc.Pages[i] = ExportPage{Number: i + 1, Error: errs[i].Error()}
+			continue
+		}
+		doc.Pages[i] = exportPage(i+1, result)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal result: %v", err)
+	}
+	return data, nil
+}
+
+func exportPage(number int, result *Result) ExportPage {
+	profile := result.Profile
+	page := ExportPage{
+		Number:    number,
+		OCRText:   result.OCRText,
+		Text:      result.Text,
+		Legible:   result.Legible,
+		Uncertain: result.Uncertain,
+		Profile: &ExportProfile{
+			Name:        profile.Name,
+			OCRModel:    profile.OCRModel,
+			LLMModel:    profile.LLMModel,
+			Temperature: profile.Temperature,
+			Correct:     profile.Correct,
+			OCRTimeout:  profile.OCRTimeout.Seconds(),
+			LLMTimeout:  profile.LLMTimeout.Seconds(),
+		},
+		Timing: &ExportTiming{
+			OCR:        result.Timing.OCRTime,
+			Correction: result.Timing.GPTTime,
+			Total:      result.Timing.TotalTime,
+		},
+	}
+	if result.OCR == nil {
+		return page
+	}
+
+	ocr := result.OCR
+	page.Engine = ocr.Engine
+	page.Width, page.Height = ocr.Width, ocr.Height
+	page.Rotation = ocr.Rotation
+	page.Languages = ocr.Languages
+	for _, e := range ocr.Entities {
+		page.Entities = append(page.Entities, ExportEntity{Name: e.Name, Text: e.Text})
+	}
+	aligned := alignCorrection(result)
+	for b := range ocr.Blocks {
+		block := &ocr.Blocks[b]
+		eb := ExportBlock{
+			Box:        exportBox(block.Box, block.Polygon),
+			Polygon:    exportPolygon(block.Polygon),
+			LayoutType: block.LayoutType,
+			Confidence: exportConfidence(block.Confidence),
+			Lines:      make([]ExportLine, len(block.Lines)),
+		}
+		for _, lang := range block.Languages {
+			eb.Languages = append(eb.Languages, ExportLanguage{Code: lang.Code, Confidence: exportConfidence(lang.Confidence)})
+		}
+		for l := range block.Lines {
+			line := &block.Lines[l]
+			el := ExportLine{
+				Text:        line.Text,
+				Box:         exportBox(line.Box, line.Polygon),
+				Polygon:     exportPolygon(line.Polygon),
+				Orientation: line.Orientation,
+				Confidence:  exportConfidence(line.Confidence),
+				Words:       make([]ExportWord, len(line.Words)),
+			}
+			for w := range line.Words {
+				word := &line.Words[w]
+				ew := ExportWord{
+					Text:       word.Text,
+					Corrected:  aligned[word],
+					Box:        exportBox(word.Box, word.Polygon),
+					Polygon:    exportPolygon(word.Polygon),
+					Confidence: exportConfidence(word.Confidence),
+				}
+				if word.EntityIndex >= 0 && word.EntityIndex < len(ocr.Entities) {
+					ew.Entity = &word.EntityIndex
+				}
+				el.Words[w] = ew
+			}
+			eb.Lines[l] = el
+		}
+		page.Blocks = append(page.Blocks, eb)
+	}
+	return page
+}
+
+func exportBox(box BoundingBox, polygon []Point) ExportBox {
+	box = layoutBox(box, polygon)
+	return ExportBox{X: box.X, Y: box.Y, Width: box.Width, Height: box.Height}
+}
+
+func exportPolygon(polygon []Point) []ExportPoint {
+	var points []ExportPoint
+	for _, p := range polygon {
+		points = append(points, ExportPoint{X: p.X, Y: p.Y})
+	}
+	return points
+}
+
+// exportConfidence turns ConfidenceUnknown into null.
+func exportConfidence(c float64) *float64 {
+	if c < 0 {
+		return nil
+	}
+	return &c
+}
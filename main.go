@@ -1,44 +1,82 @@
-package main
-
-import (
-	"log"
-	"os"
-
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/joho/godotenv"
-)
-
-func init() {
-
-	err := godotenv.Load(".env")
-
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-}
-
-func main() {
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN is not set")
-	}
-
-	bot, err := tgbotapi.NewBotAPI(botToken)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	bot.Debug = true
-	log.Printf("Authorized on account %s", bot.Self.UserName)
-
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	updates := bot.GetUpdatesChan(u)
-
-	go UpdateIAM()
-
-	for update := range updates {
-		go handleUpdate(bot, update)
-	}
-}
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	// Без .env переменные берутся из окружения процесса
+	if err := godotenv.Load(".env"); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+
+	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if botToken == "" {
+		log.Fatal("TELEGRAM_BOT_TOKEN is not set")
+	}
+
+	bot, err := tgbotapi.NewBotAPI(botToken)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bot.Debug = true
+	log.Printf("Authorized on account %s", bot.Self.UserName)
+
+	// Недостающие переводы не ломают бота, но о них стоит знать
+	for _, problem := range checkCatalogs() {
+		log.Print(problem)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	updates := bot.GetUpdatesChan(u)
+
+	settingsPath := os.Getenv("SETTINGS_FILE")
+	if settingsPath == "" {
+		settingsPath = "settings.json"
+	}
+	store, err := NewFileSettingsStore(settingsPath)
+	if err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	settingsStore = store
+	jobQueue = NewJobQueueFromEnv()
+
+	tokens, err := NewIAMTokenSource()
+	if err != nil {
+		log.Fatalf("Failed to configure Yandex credentials: %v", err)
+	}
+	if provider, ok := tokens.(*IAMTokenProvider); ok {
+		go provider.Run(context.Background())
+	}
+	if tokens == nil {
+		log.Print("No Yandex credentials set, Yandex OCR is disabled")
+	} else {
+		iamTokens = tokens
+	}
+
+	// Клиент корректора и проверка IP — один раз, а не на каждое задание
+	checkProxyIP()
+	if _, err := sharedHTTPClient(); err != nil {
+		log.Printf("Failed to configure corrector HTTP client: %v", err)
+	}
+
+	sessions := NewSessionManager()
+	albums = NewAlbumCollector(albumDelay, func(chatID int64, fileIDs []string) {
+		sessions.Dispatch(chatID, func() { handleAlbum(bot, chatID, fileIDs) })
+	})
+	for update := range updates {
+		chatID, ok := updateChatID(update)
+		if !ok {
+			continue
+		}
+		sessions.Dispatch(chatID, func() { handleUpdate(bot, update) })
+	}
+}
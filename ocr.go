@@ -1,421 +1,551 @@
-package main
-
-import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
-
-	"golang.org/x/net/proxy"
-)
-
-// OCRResponse defines the structure for Yandex OCR API responses.
-type OCRResponse struct {
-	Result struct {
-		TextAnnotation struct {
-			Width  string `json:"width"`
-			Height string `json:"height"`
-			Blocks []struct {
-				BoundingBox struct {
-					Vertices []struct {
-						X string `json:"x"`
-						Y string `json:"y"`
-					} `json:"vertices"`
-				} `json:"bounding_box"`
-				Lines []struct {
-					Words []struct {
-						Text string `json:"text"`
-					} `json:"words"`
-				} `json:"lines"`
-			} `json:"blocks"`
-			FullText string `json:"fullText"`
-		} `json:"textAnnotation"`
-	} `json:"result"`
-	Error struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
-}
-
-// MistralResponse defines the structure for Mistral Chat API responses.
-type MistralResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
-		} `json:"message"`
-	} `json:"choices"`
-	Error struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-	} `json:"error"`
-}
-
-// Timing tracks the duration of OCR, Mistral API, and total processing.
-type Timing struct {
-	OCRTime   float64
-	GPTTime   float64
-	TotalTime float64
-}
-
-// logTiming writes timing metrics to a log file.
-func logTiming(timing Timing) {
-	logEntry := fmt.Sprintf(
-		"[%s] OCR: %.2f sec, Mistral: %.2f sec, Total: %.2f sec\n",
-		time.Now().Format(time.RFC3339),
-		timing.OCRTime,
-		timing.GPTTime,
-		timing.TotalTime,
-	)
-	f, err := os.OpenFile("timing.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error opening timing.log: %v\n", err)
-		return
-	}
-	defer f.Close()
-	if _, err := f.WriteString(logEntry); err != nil {
-		fmt.Printf("Error writing to timing.log: %v\n", err)
-	}
-}
-
-// YandexOCR performs OCR on an image using the Yandex OCR API.
-func YandexOCR(imagePath, folderID, iamToken string) (string, float64, error) {
-	start := time.Now()
-	url := "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeText"
-
-	fileInfo, err := os.Stat(imagePath)
-	if err != nil {
-		return "", 0, fmt.Errorf("check file: %v", err)
-	}
-	if fileInfo.Size() == 0 {
-		return "", 0, fmt.Errorf("image file is empty")
-	}
-
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return "", 0, fmt.Errorf("open image: %v", err)
-	}
-	defer file.Close()
-
-	imgBytes, err := io.ReadAll(file)
-	if err != nil {
-		return "", 0, fmt.Errorf("read image: %v", err)
-	}
-	if len(imgBytes) == 0 {
-		return "", 0, fmt.Errorf("image data is empty")
-	}
-	imgBase64 := base64.StdEncoding.EncodeToString(imgBytes)
-	if imgBase64 == "" {
-		return "", 0, fmt.Errorf("base64 encoding failed")
-	}
-
-	payload := map[string]interface{}{
-		"mimeType":      "image/jpeg",
-		"languageCodes": []string{"ru"},
-		"model":         "handwritten",
-		"content":       imgBase64,
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", 0, fmt.Errorf("marshal payload: %v", err)
-	}
-
-	fmt.Printf("OCR Request Body: %s\n", string(body))
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return "", 0, fmt.Errorf("create request: %v", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+iamToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-folder-id", folderID)
-	req.Header.Set("x-data-logging-enabled", "true")
-
-	client := &http.Client{
-		Timeout: 30 * time.Second, // Increased timeout for reliability
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", 0, fmt.Errorf("send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("OCR failed: status %d, body: %s", resp.StatusCode, string(respBody))
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", 0, fmt.Errorf("read response: %v", err)
-	}
-
-	if err := os.WriteFile("api_response.json", respBody, 0644); err != nil {
-		return "", 0, fmt.Errorf("write api_response.json: %v", err)
-	}
-
-	var ocrResp OCRResponse
-	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
-		return "", 0, fmt.Errorf("unmarshal response: %v", err)
-	}
-
-	if ocrResp.Error.Message != "" {
-		return "", time.Since(start).Seconds(), fmt.Errorf("OCR error: %s", ocrResp.Error.Message)
-	}
-
-	text := ocrResp.Result.TextAnnotation.FullText
-	if text == "" {
-		for _, block := range ocrResp.Result.TextAnnotation.Blocks {
-			for _, line := range block.Lines {
-				for _, word := range line.Words {
-					text += word.Text + " "
-				}
-				text += "\n"
-			}
-		}
-	}
-
-	if text == "" {
-		return "", time.Since(start).Seconds(), fmt.Errorf("empty text detected")
-	}
-
-	return strings.TrimSpace(text), time.Since(start).Seconds(), nil
-}
-
-// checkIP verifies the public IP address, with or without a proxy.
-func checkIP(useProxy bool, proxyAddr string) (string, error) {
-	url := "https://api.ipify.org?format=text"
-
-	var client *http.Client
-	if useProxy {
-		dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
-		if err != nil {
-			return "", fmt.Errorf("failed to setup SOCKS5 proxy: %v", err)
-		}
-		transport := &http.Transport{
-			Dial: dialer.Dial,
-		}
-		client = &http.Client{
-			Transport: transport,
-			Timeout:   30 * time.Second,
-		}
-	} else {
-		client = &http.Client{
-			Timeout: 30 * time.Second,
-		}
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-
-	return string(body), nil
-}
-
-// logIP logs the IP check results.
-func logIP(ip string, useProxy bool, proxyAddr string, err error) {
-	logEntry := fmt.Sprintf(
-		"[%s] Proxy: %v, ProxyAddr: %s, IP: %s, Error: %v\n",
-		time.Now().Format(time.RFC3339),
-		useProxy,
-		proxyAddr,
-		ip,
-		err,
-	)
-	fmt.Print(logEntry)
-	f, err := os.OpenFile("proxy_check.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error opening proxy_check.log: %v\n", err)
-		return
-	}
-	defer f.Close()
-	if _, err := f.WriteString(logEntry); err != nil {
-		fmt.Printf("Error writing to proxy_check.log: %v\n", err)
-	}
-}
-
-// MistralAPI interacts with the Mistral Chat API to correct OCR text.
-// Requires MISTRAL_API_KEY environment variable.
-// On Windows, set DNS to 8.8.8.8 or 1.1.1.1 if DNS resolution fails (Control Panel > Network > Adapter > IPv4 > DNS).
-func MistralAPI(text, apiKey string) (string, float64, error) {
-	start := time.Now()
-	url := "https://api.mistral.ai/v1/chat/completions"
-
-	// Model and proxy settings from environment variables
-	model := os.Getenv("MISTRAL_MODEL")
-	if model == "" {
-		model = "mistral-large-latest" // Default per Mistral API docs
-	}
-
-	useProxy := os.Getenv("USE_PROXY") == "true" // Default to false unless explicitly true
-	proxyAddr := os.Getenv("PROXY_ADDR")
-	if proxyAddr == "" {
-		proxyAddr = "127.0.0.1:10808"
-	}
-
-	// Check IP for debugging network issues
-	fmt.Printf("Checking IP (Proxy: %v, Addr: %s)...\n", useProxy, proxyAddr)
-	ip, err := checkIP(useProxy, proxyAddr)
-	logIP(ip, useProxy, proxyAddr, err)
-	if err != nil {
-		fmt.Printf("IP check failed: %v\n", err)
-	}
-
-	// Configure HTTP client
-	var client *http.Client
-	if useProxy {
-		dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
-		if err != nil {
-			return "", 0, fmt.Errorf("setup SOCKS5 proxy: %v", err)
-		}
-		transport := &http.Transport{
-			Dial: dialer.Dial,
-		}
-		client = &http.Client{
-			Transport: transport,
-			Timeout:   30 * time.Second, // Increased for reliability
-		}
-	} else {
-		client = &http.Client{
-			Timeout: 30 * time.Second,
-		}
-	}
-
-	// Construct payload per Mistral API specs
-	payload := map[string]interface{}{
-		"model": model,
-		"messages": []map[string]interface{}{
-			{
-				"role": "user",
-				"content": fmt.Sprintf(
-					"Исправьте ошибки OCR в тексте, сохраняя оригинальный язык и переносы строк. Исправляйте ТОЛЬКО явные орфографические ошибки или неполные слова на основе написания и контекста. Не добавляйте и не удаляйте слова, не изменяйте структуру, порядок слов, пунктуацию, смысл и самое главное - переносы строк, даже если текст нелогичен. Сводите исправления к минимуму. Возвращайте только исправленный текст без дополнительных комментариев. если текст довольно неразборчивый, в самом конце добавляй текст \"слишком неразборчиво 9905148\".\n\n%s",
-					text,
-				),
-			},
-		},
-		"temperature": 0.3,
-		"max_tokens":  2000,
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", 0, fmt.Errorf("marshal payload: %v", err)
-	}
-	fmt.Printf("Mistral Request Body: %s\n", string(body))
-
-	// Retry logic for transient network issues
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		fmt.Printf("Mistral API attempt %d/%d at %s\n", attempt, maxRetries, time.Now().Format(time.RFC3339))
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-		if err != nil {
-			return "", 0, fmt.Errorf("create request: %v", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Printf("Attempt %d failed: %v\n", attempt, err)
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second) // Exponential backoff
-				continue
-			}
-			return "", 0, fmt.Errorf("send request after %d attempts: %v", maxRetries, err)
-		}
-		defer resp.Body.Close()
-
-		fmt.Printf("Mistral Response Status: %d\n", resp.StatusCode)
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("Attempt %d failed to read response: %v\n", attempt, err)
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", 0, fmt.Errorf("read response after %d attempts: %v", maxRetries, err)
-		}
-
-		// Save response for debugging
-		responseFile := filepath.Join("mistral_response.json")
-		if err := os.WriteFile(responseFile, respBody, 0644); err != nil {
-			fmt.Printf("Error writing %s: %v\n", responseFile, err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Attempt %d failed with status: %d, body: %s\n", attempt, resp.StatusCode, string(respBody))
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", 0, fmt.Errorf("Mistral failed: status %d, body: %s", resp.StatusCode, string(respBody))
-		}
-
-		var mistralResp MistralResponse
-		if err := json.Unmarshal(respBody, &mistralResp); err != nil {
-			fmt.Printf("Attempt %d failed to unmarshal: %v\n", attempt, err)
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", 0, fmt.Errorf("unmarshal response after %d attempts: %v", maxRetries, err)
-		}
-
-		if mistralResp.Error.Message != "" {
-			return "", time.Since(start).Seconds(), fmt.Errorf("Mistral error: %s (type: %s)", mistralResp.Error.Message, mistralResp.Error.Type)
-		}
-
-		if len(mistralResp.Choices) == 0 || mistralResp.Choices[0].Message.Content == "" {
-			fmt.Printf("Attempt %d: no valid response, body: %s\n", attempt, string(respBody))
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", time.Since(start).Seconds(), fmt.Errorf("no Mistral response, body: %s", string(respBody))
-		}
-
-		return strings.TrimSpace(mistralResp.Choices[0].Message.Content), time.Since(start).Seconds(), nil
-	}
-
-	return "", 0, fmt.Errorf("Mistral request failed after %d attempts", maxRetries)
-}
-
-// ProcessImage orchestrates OCR and Mistral API processing.
-func ProcessImage(imagePath, folderID, iamToken, mistralAPIKey string) (string, string, Timing, error) {
-	startTotal := time.Now()
-	ocrText, ocrTime, err := YandexOCR(imagePath, folderID, iamToken)
-	if err != nil {
-		return "", "", Timing{OCRTime: ocrTime}, fmt.Errorf("OCR: %v", err)
-	}
-	gptText, gptTime, err := MistralAPI(ocrText, mistralAPIKey)
-	if err != nil {
-		return ocrText, "", Timing{OCRTime: ocrTime, GPTTime: gptTime}, fmt.Errorf("Mistral: %v", err)
-	}
-	totalTime := time.Since(startTotal).Seconds()
-	timing := Timing{OCRTime: ocrTime, GPTTime: gptTime, TotalTime: totalTime}
-	logTiming(timing)
-	return ocrText, gptText, timing, nil
-}
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// OCRResponse defines the structure for Yandex OCR API responses.
+type OCRResponse struct {
+	Result struct {
+		TextAnnotation yandexTextAnnotation `json:"textAnnotation"`
+		Page           string               `json:"page"` // Zero-based page number in async document results
+	} `json:"result"`
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type yandexTextAnnotation struct {
+	Width    string         `json:"width"`
+	Height   string         `json:"height"`
+	Blocks   []yandexBlock  `json:"blocks"`
+	Entities []yandexEntity `json:"entities"`
+	FullText string         `json:"fullText"`
+	Rotate   string         `json:"rotate"` // ANGLE_0, ANGLE_90, ANGLE_180 or ANGLE_270
+}
+
+type yandexBlock struct {
+	BoundingBox  yandexBoundingBox   `json:"boundingBox"`
+	Lines        []yandexLine        `json:"lines"`
+	Languages    []yandexLanguage    `json:"languages"`
+	TextSegments []yandexTextSegment `json:"textSegments"`
+	LayoutType   string              `json:"layoutType"`
+	Confidence   *float64            `json:"confidence"`
+}
+
+type yandexLine struct {
+	BoundingBox  yandexBoundingBox   `json:"boundingBox"`
+	Text         string              `json:"text"`
+	Words        []yandexWord        `json:"words"`
+	TextSegments []yandexTextSegment `json:"textSegments"`
+	Orientation  string              `json:"orientation"`
+	Confidence   *float64            `json:"confidence"`
+}
+
+type yandexWord struct {
+	BoundingBox  yandexBoundingBox   `json:"boundingBox"`
+	Text         string              `json:"text"`
+	EntityIndex  string              `json:"entityIndex"`
+	TextSegments []yandexTextSegment `json:"textSegments"`
+	Confidence   *float64            `json:"confidence"`
+}
+
+type yandexLanguage struct {
+	LanguageCode string   `json:"languageCode"`
+	Confidence   *float64 `json:"confidence"`
+}
+
+// yandexTextSegment points into fullText; both fields are int64 strings.
+type yandexTextSegment struct {
+	StartIndex string `json:"startIndex"`
+	Length     string `json:"length"`
+}
+
+type yandexEntity struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// yandexBoundingBox is a polygon with coordinates encoded as strings.
+type yandexBoundingBox struct {
+	Vertices []struct {
+		X string `json:"x"`
+		Y string `json:"y"`
+	} `json:"vertices"`
+}
+
+// polygon returns the vertices as integer points.
+func (b yandexBoundingBox) polygon() []Point {
+	points := make([]Point, 0, len(b.Vertices))
+	for _, v := range b.Vertices {
+		x, _ := strconv.Atoi(v.X)
+		y, _ := strconv.Atoi(v.Y)
+		points = append(points, Point{X: x, Y: y})
+	}
+	return points
+}
+
+// yandexAngle converts an ANGLE_* enum value into degrees.
+func yandexAngle(angle string) int {
+	degrees, _ := strconv.Atoi(strings.TrimPrefix(angle, "ANGLE_"))
+	return degrees
+}
+
+// yandexConfidence maps an optional confidence onto OCR's convention.
+func yandexConfidence(c *float64) float64 {
+	if c == nil {
+		return ConfidenceUnknown
+	}
+	return *c
+}
+
+// yandexSegments converts text segments into offsets.
+func yandexSegments(segments []yandexTextSegment) []TextSegment {
+	var out []TextSegment
+	for _, seg := range segments {
+		start, _ := strconv.Atoi(seg.StartIndex)
+		length, _ := strconv.Atoi(seg.Length)
+		out = append(out, TextSegment{Start: start, Length: length})
+	}
+	return out
+}
+
+// Timing tracks the duration of OCR, text correction, and total processing.
+type Timing struct {
+	OCRTime   float64
+	GPTTime   float64
+	TotalTime float64
+}
+
+// logTiming writes timing metrics to a log file.
+func logTiming(profile string, timing Timing) {
+	logEntry := fmt.Sprintf(
+		"[%s] Profile: %s, OCR: %.2f sec, Correction: %.2f sec, Total: %.2f sec\n",
+		time.Now().Format(time.RFC3339),
+		profile,
+		timing.OCRTime,
+		timing.GPTTime,
+		timing.TotalTime,
+	)
+	f, err := os.OpenFile("timing.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Error opening timing.log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(logEntry); err != nil {
+		fmt.Printf("Error writing to timing.log: %v\n", err)
+	}
+}
+
+// YandexOCREngine performs OCR using the Yandex OCR API.
+type YandexOCREngine struct {
+	URL          string // recognizeText endpoint
+	AsyncURL     string // recognizeTextAsync endpoint
+	ResultURL    string // getRecognition endpoint
+	OperationURL string // Operation service base, the operation ID is appended
+	FolderID     string
+	Tokens       TokenSource
+}
+
+// iamTokens provides IAM tokens to Yandex engines; main configures it.
+var iamTokens TokenSource
+
+func newYandexOCREngine() (OCREngine, error) {
+	folderID := os.Getenv("FOLDER_ID")
+	if iamTokens == nil || folderID == "" {
+		return nil, fmt.Errorf("%w: Yandex credentials or FOLDER_ID not set", ErrNotConfigured)
+	}
+	return &YandexOCREngine{
+		URL:          "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeText",
+		AsyncURL:     "https://ocr.api.cloud.yandex.net/ocr/v1/recognizeTextAsync",
+		ResultURL:    "https://ocr.api.cloud.yandex.net/ocr/v1/getRecognition",
+		OperationURL: "https://operation.api.cloud.yandex.net/operations/",
+		FolderID:     folderID,
+		Tokens:       iamTokens,
+	}, nil
+}
+
+// Name implements OCREngine.
+func (e *YandexOCREngine) Name() string { return "yandex" }
+
+// Recognize implements OCREngine.
+func (e *YandexOCREngine) Recognize(image []byte, opts OCROptions) (*OCRResult, error) {
+	body, err := e.requestBody(image, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second // Increased timeout for reliability
+	}
+	respBody, err := e.call("POST", e.URL, body, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile("api_response.json", respBody, 0644); err != nil {
+		return nil, fmt.Errorf("write api_response.json: %v", err)
+	}
+
+	var ocrResp OCRResponse
+	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %v", err)
+	}
+
+	if ocrResp.Error.Message != "" {
+		return nil, fmt.Errorf("OCR error: %s", ocrResp.Error.Message)
+	}
+
+	result := e.convert(ocrResp)
+	if result.Text() == "" {
+		return nil, fmt.Errorf("empty text detected")
+	}
+
+	return result, nil
+}
+
+// requestBody builds the recognizeText/recognizeTextAsync payload.
+func (e *YandexOCREngine) requestBody(content []byte, opts OCROptions) ([]byte, error) {
+	if len(content) == 0 {
+		return nil, fmt.Errorf("image data is empty")
+	}
+
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	languageCodes := opts.LanguageCodes
+	if len(languageCodes) == 0 {
+		languageCodes = []string{"ru"}
+	}
+	model := opts.Model
+	if model == "" {
+		model = "handwritten"
+	}
+
+	payload := map[string]interface{}{
+		"mimeType":      mimeType,
+		"languageCodes": languageCodes,
+		"model":         model,
+		"content":       base64.StdEncoding.EncodeToString(content),
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %v", err)
+	}
+	fmt.Printf("OCR Request: mimeType %s, model %s, languages %v, %d bytes\n", mimeType, model, languageCodes, len(content))
+	return body, nil
+}
+
+// call sends an authorized request to a Yandex Cloud API and returns the
+// body of a successful response.
+func (e *YandexOCREngine) call(method, url string, body []byte, timeout time.Duration) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %v", err)
+	}
+	iamToken, err := e.Tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("IAM token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+iamToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-folder-id", e.FolderID)
+	req.Header.Set("x-data-logging-enabled", "true")
+
+	client := &http.Client{
+		Timeout: timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCR failed: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+// convert maps the API response onto the engine-neutral OCRResult.
+func (e *YandexOCREngine) convert(ocrResp OCRResponse) *OCRResult {
+	annotation := ocrResp.Result.TextAnnotation
+	result := &OCRResult{
+		Engine:   e.Name(),
+		FullText: annotation.FullText,
+		Rotation: yandexAngle(annotation.Rotate),
+	}
+	result.Width, _ = strconv.Atoi(annotation.Width)
+	result.Height, _ = strconv.Atoi(annotation.Height)
+	for _, ent := range annotation.Entities {
+		result.Entities = append(result.Entities, OCREntity{Name: ent.Name, Text: ent.Text})
+	}
+
+	seenLanguages := make(map[string]bool)
+	for _, b := range annotation.Blocks {
+		block := OCRBlock{
+			Polygon:    b.BoundingBox.polygon(),
+			LayoutType: b.LayoutType,
+			Confidence: yandexConfidence(b.Confidence),
+			Segments:   yandexSegments(b.TextSegments),
+		}
+		block.Box = polygonBox(block.Polygon)
+		for _, lang := range b.Languages {
+			block.Languages = append(block.Languages, OCRLanguage{
+				Code:       lang.LanguageCode,
+				Confidence: yandexConfidence(lang.Confidence),
+			})
+			if lang.LanguageCode != "" && !seenLanguages[lang.LanguageCode] {
+				seenLanguages[lang.LanguageCode] = true
+				result.Languages = append(result.Languages, lang.LanguageCode)
+			}
+		}
+
+		for _, l := range b.Lines {
+			line := OCRLine{
+				Text:        l.Text,
+				Polygon:     l.BoundingBox.polygon(),
+				Orientation: yandexAngle(l.Orientation),
+				Confidence:  yandexConfidence(l.Confidence),
+				Segments:    yandexSegments(l.TextSegments),
+			}
+			line.Box = polygonBox(line.Polygon)
+			texts := make([]string, 0, len(l.Words))
+			for _, w := range l.Words {
+				word := OCRWord{
+					Text:        w.Text,
+					Polygon:     w.BoundingBox.polygon(),
+					Confidence:  yandexConfidence(w.Confidence),
+					EntityIndex: -1,
+					Segments:    yandexSegments(w.TextSegments),
+				}
+				word.Box = polygonBox(word.Polygon)
+				if idx, err := strconv.Atoi(w.EntityIndex); err == nil {
+					word.EntityIndex = idx
+				}
+				line.Words = append(line.Words, word)
+				texts = append(texts, w.Text)
+			}
+			if line.Text == "" {
+				line.Text = strings.Join(texts, " ")
+			}
+			block.Lines = append(block.Lines, line)
+		}
+		result.Blocks = append(result.Blocks, block)
+	}
+	return result
+}
+
+// checkIP verifies the public IP address, with or without a proxy.
+func checkIP(useProxy bool, proxyAddr string) (string, error) {
+	url := "https://api.ipify.org?format=text"
+
+	var client *http.Client
+	if useProxy {
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+		if err != nil {
+			return "", fmt.Errorf("failed to setup SOCKS5 proxy: %v", err)
+		}
+		transport := &http.Transport{
+			Dial: dialer.Dial,
+		}
+		client = &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		}
+	} else {
+		client = &http.Client{
+			Timeout: 30 * time.Second,
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	return string(body), nil
+}
+
+// logIP logs the IP check results.
+func logIP(ip string, useProxy bool, proxyAddr string, err error) {
+	logEntry := fmt.Sprintf(
+		"[%s] Proxy: %v, ProxyAddr: %s, IP: %s, Error: %v\n",
+		time.Now().Format(time.RFC3339),
+		useProxy,
+		proxyAddr,
+		ip,
+		err,
+	)
+	fmt.Print(logEntry)
+	f, err := os.OpenFile("proxy_check.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Error opening proxy_check.log: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(logEntry); err != nil {
+		fmt.Printf("Error writing to proxy_check.log: %v\n", err)
+	}
+}
+
+// Page is one image to recognize; albums and documents consist of several.
+type Page struct {
+	Image    []byte
+	MimeType string
+}
+
+// Result is the outcome of the recognition pipeline for one image.
+type Result struct {
+	Source    Page            // Recognized image, empty for document pages
+	OCR       *OCRResult      // Structured OCR output
+	OCRText   string          // Raw recognized text
+	Text      string          // Corrected text, equal to OCRText when correction is off
+	Legible   bool            // False when the corrector found the handwriting illegible
+	Uncertain []string        // Fragments of Text the corrector is unsure about
+	Profile   PipelineProfile // Profile the pipeline ran with
+	Timing    Timing
+}
+
+// ProcessImage orchestrates OCR and text correction according to profile.
+func ProcessImage(engine OCREngine, corrector Corrector, image []byte, opts OCROptions, profile PipelineProfile) (*Result, error) {
+	startTotal := time.Now()
+	result := &Result{Source: Page{Image: image, MimeType: opts.MimeType}, Profile: profile}
+
+	opts.Model = profile.OCRModel
+	opts.Timeout = profile.OCRTimeout
+	startOCR := time.Now()
+	ocrResult, err := engine.Recognize(image, opts)
+	result.Timing.OCRTime = time.Since(startOCR).Seconds()
+	if err != nil {
+		return result, fmt.Errorf("OCR: %w", err)
+	}
+	result.OCR = ocrResult
+	result.OCRText = ocrResult.Text()
+	result.Text = result.OCRText
+	result.Legible = true
+
+	if err := correctResult(corrector, result, opts.LanguageCodes); err != nil {
+		return result, err
+	}
+
+	result.Timing.TotalTime = time.Since(startTotal).Seconds()
+	logTiming(profile.Name, result.Timing)
+	return result, nil
+}
+
+// ProcessDocument recognizes a multi-page document such as a PDF and
+// corrects every page. It returns one result and one error per page, or a
+// single failed result when the document could not be recognized at all.
+func ProcessDocument(engine OCREngine, corrector Corrector, document []byte, opts OCROptions, profile PipelineProfile) ([]*Result, []error) {
+	startTotal := time.Now()
+	recognizer, ok := engine.(DocumentRecognizer)
+	if !ok {
+		return []*Result{{Profile: profile}}, []error{fmt.Errorf("OCR: engine %s does not support documents", engine.Name())}
+	}
+
+	opts.Model = profile.OCRModel
+	opts.Timeout = profile.OCRTimeout
+	pages, err := recognizer.RecognizeDocument(document, opts)
+	ocrTime := time.Since(startTotal).Seconds()
+	if err != nil {
+		return []*Result{{Profile: profile, Timing: Timing{OCRTime: ocrTime}}}, []error{fmt.Errorf("OCR: %w", err)}
+	}
+
+	results := make([]*Result, len(pages))
+	errs := make([]error, len(pages))
+	for i, page := range pages {
+		startPage := time.Now()
+		result := &Result{
+			OCR:     page,
+			OCRText: page.Text(),
+			Profile: profile,
+			Timing:  Timing{OCRTime: ocrTime / float64(len(pages))},
+			Legible: true,
+		}
+		result.Text = result.OCRText
+		errs[i] = correctResult(corrector, result, opts.LanguageCodes)
+		result.Timing.TotalTime = result.Timing.OCRTime + time.Since(startPage).Seconds()
+		results[i] = result
+	}
+	logTiming(profile.Name, Timing{
+		OCRTime:   ocrTime,
+		GPTTime:   time.Since(startTotal).Seconds() - ocrTime,
+		TotalTime: time.Since(startTotal).Seconds(),
+	})
+	return results, errs
+}
+
+// correctResult runs the correction step on result.OCRText when the profile
+// asks for it. Blank pages are left as they are. languages are the document
+// languages requested from OCR; with auto-detection the languages the engine
+// detected are passed on instead.
+func correctResult(corrector Corrector, result *Result, languages []string) error {
+	profile := result.Profile
+	if !profile.Correct || result.OCRText == "" {
+		return nil
+	}
+	if (len(languages) == 0 || slices.Contains(languages, AutoLanguage)) && result.OCR != nil {
+		languages = result.OCR.Languages
+	}
+	startGPT := time.Now()
+	correction, err := corrector.Correct(result.OCRText, CorrectOptions{
+		Model:       profile.LLMModel,
+		Temperature: profile.Temperature,
+		Timeout:     profile.LLMTimeout,
+		Languages:   languages,
+	})
+	result.Timing.GPTTime = time.Since(startGPT).Seconds()
+	if err != nil {
+		result.Text = ""
+		return fmt.Errorf("%s: %v", corrector.Name(), err)
+	}
+	result.Text = correction.Text
+	if result.Text == "" {
+		// Модель ничего не вернула — показываем текст OCR, но сохраняем её вердикт
+		result.Text = result.OCRText
+	}
+	result.Legible = correction.Legible
+	result.Uncertain = correction.Uncertain
+	return nil
+}
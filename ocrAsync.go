@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// documentTimeout bounds the whole asynchronous recognition of a document.
+	documentTimeout = 10 * time.Minute
+	// documentPollInterval is the delay between operation status checks.
+	documentPollInterval = 2 * time.Second
+)
+
+// yandexOperation is a long-running operation of the Yandex Cloud API.
+type yandexOperation struct {
+	ID    string `json:"id"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// RecognizeDocument implements DocumentRecognizer using recognizeTextAsync:
+// it starts the operation, polls it until done and returns the pages in
+// document order.
+func (e *YandexOCREngine) RecognizeDocument(document []byte, opts OCROptions) ([]*OCRResult, error) {
+	body, err := e.requestBody(document, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := e.call("POST", e.AsyncURL, body, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	var op yandexOperation
+	if err := json.Unmarshal(respBody, &op); err != nil {
+		return nil, fmt.Errorf("unmarshal operation: %v", err)
+	}
+	if op.ID == "" {
+		return nil, fmt.Errorf("no operation ID in response: %s", string(respBody))
+	}
+
+	timeout := opts.Timeout
+	if timeout < documentTimeout {
+		timeout = documentTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for !op.Done {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("operation %s not done after %s", op.ID, timeout)
+		}
+		time.Sleep(documentPollInterval)
+		respBody, err := e.call("GET", e.OperationURL+op.ID, nil, 30*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("poll operation %s: %v", op.ID, err)
+		}
+		if err := json.Unmarshal(respBody, &op); err != nil {
+			return nil, fmt.Errorf("unmarshal operation: %v", err)
+		}
+	}
+	if op.Error != nil && op.Error.Message != "" {
+		return nil, fmt.Errorf("OCR error: %s", op.Error.Message)
+	}
+
+	respBody, err = e.call("GET", e.ResultURL+"?operationId="+url.QueryEscape(op.ID), nil, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("get recognition %s: %v", op.ID, err)
+	}
+	return e.convertPages(respBody)
+}
+
+// convertPages parses a getRecognition response, which is a stream of JSON
+// objects with one recognizeText-style result per page.
+func (e *YandexOCREngine) convertPages(respBody []byte) ([]*OCRResult, error) {
+	type page struct {
+		index  int
+		result *OCRResult
+	}
+	var pages []page
+
+	dec := json.NewDecoder(bytes.NewReader(respBody))
+	for {
+		var ocrResp OCRResponse
+		err := dec.Decode(&ocrResp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal page: %v", err)
+		}
+		if ocrResp.Error.Message != "" {
+			return nil, fmt.Errorf("OCR error: %s", ocrResp.Error.Message)
+		}
+		index, _ := strconv.Atoi(ocrResp.Result.Page)
+		pages = append(pages, page{index: index, result: e.convert(ocrResp)})
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].index < pages[j].index })
+	results := make([]*OCRResult, len(pages))
+	for i, p := range pages {
+		results[i] = p.result
+	}
+	return results, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// buildPDF renders one section per page; each page starts on a new sheet.
+// With a positive highlight threshold doubtful words are printed in red.
+func buildPDF(chatID int64, results []*Result, errs []error, highlight float64) ([]byte, error) {
+	// Создаем PDF
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8Font("DejaVu", "", "DejaVuSans.ttf")
+	for i, result := range results {
+		addTextPage(pdf, chatID, i, len(results), result, errs[i], highlight)
+	}
+
+	// Конвертируем PDF в байты
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildSearchablePDF works like a scanner: every page shows the original
+// image with the recognized words laid over it as invisible, selectable
+// text. Pages without an image, such as PDF input, fall back to text pages.
+func buildSearchablePDF(chatID int64, results []*Result, errs []error, highlight float64) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8Font("DejaVu", "", "DejaVuSans.ttf")
+	for i, result := range results {
+		if errs[i] != nil || !addScanPage(pdf, fmt.Sprintf("page%d", i), result) {
+			addTextPage(pdf, chatID, i, len(results), result, errs[i], highlight)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addTextPage writes a result as plain text on a new A4 page.
+func addTextPage(pdf *gofpdf.Fpdf, chatID int64, index, total int, result *Result, err error, highlight float64) {
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddPage()
+	if total > 1 {
+		pdf.SetFont("DejaVu", "", 14)
+		pdf.MultiCell(190, 7, pageHeader(chatID, index+1, total), "", "", false)
+		pdf.Ln(3)
+	}
+	pdf.SetFont("DejaVu", "", 12)
+	if err != nil {
+		pdf.MultiCell(190, 5, fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), err), "", "", false)
+		return
+	}
+	if highlight <= 0 {
+		pdf.MultiCell(190, 5, result.Text, "", "", false)
+		return
+	}
+
+	// Write переносит строки сам, поэтому текст можно выводить по словам
+	for _, span := range highlightSpans(result, highlight) {
+		if span.Marked {
+			pdf.SetTextColor(200, 0, 0)
+		}
+		pdf.Write(5, span.Text)
+		pdf.SetTextColor(0, 0, 0)
+	}
+}
+
+// scanPageWidth is the width of image pages in mm; the height follows the
+// image aspect ratio.
+const scanPageWidth = 210.0
+
+// addScanPage adds a page sized to the source image, draws the image and
+// overlays every recognized word in text rendering mode 3 (invisible),
+// stretched horizontally to its bounding box. It reports false when the
+// result has no usable image.
+func addScanPage(pdf *gofpdf.Fpdf, name string, result *Result) bool {
+	src := result.Source
+	if len(src.Image) == 0 || result.OCR == nil {
+		return false
+	}
+	imageType := map[string]string{"image/jpeg": "JPG", "image/png": "PNG"}[src.MimeType]
+	if imageType == "" {
+		return false
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src.Image))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return false
+	}
+
+	pageHeight := scanPageWidth * float64(cfg.Height) / float64(cfg.Width)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: scanPageWidth, Ht: pageHeight})
+	options := gofpdf.ImageOptions{ImageType: imageType}
+	pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(src.Image))
+	pdf.ImageOptions(name, 0, 0, scanPageWidth, pageHeight, false, options, 0, "")
+
+	// Coordinates refer to the image the engine saw, which may differ in
+	// size from the one we decoded.
+	width, height := cfg.Width, cfg.Height
+	if result.OCR.Width > 0 && result.OCR.Height > 0 {
+		width, height = result.OCR.Width, result.OCR.Height
+	}
+	sx := scanPageWidth / float64(width)
+	sy := pageHeight / float64(height)
+
+	pdf.SetFont("DejaVu", "", 12)
+	pdf.SetTextRenderingMode(3)
+	for _, block := range result.OCR.Blocks {
+		for _, line := range block.Lines {
+			for _, word := range line.Words {
+				if word.Text == "" || word.Box.Empty() {
+					continue
+				}
+				x := float64(word.Box.X) * sx
+				y := float64(word.Box.Y) * sy
+				w := float64(word.Box.Width) * sx
+				h := float64(word.Box.Height) * sy
+
+				pdf.SetFontUnitSize(h * 0.8)
+				textWidth := pdf.GetStringWidth(word.Text)
+				if textWidth <= 0 {
+					continue
+				}
+				// Horizontal scaling makes selection match the handwriting.
+				pdf.RawWriteStr(fmt.Sprintf("%.2f Tz", w/textWidth*100))
+				pdf.Text(x, y+h*0.8, word.Text)
+			}
+		}
+	}
+	pdf.RawWriteStr("100 Tz")
+	pdf.SetTextRenderingMode(0)
+	return true
+}
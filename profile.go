@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// PipelineProfile is the concrete pipeline configuration behind a model
+// choice in /settings.
+type PipelineProfile struct {
+	Name        string        // Profile key stored in UserSettings.Model
+	OCRModel    string        // Recognition model passed to the OCR engine
+	LLMModel    string        // Correction model, empty for the corrector default
+	Temperature float64       // Correction sampling temperature
+	Correct     bool          // Whether the correction step runs at all
+	OCRTimeout  time.Duration // Limit for the OCR request
+	LLMTimeout  time.Duration // Limit for the correction request
+}
+
+const (
+	ProfileBasic    = "basic"
+	ProfileImproved = "improved"
+)
+
+// ProfileNames lists the model choices offered to users, in menu order.
+var ProfileNames = []string{ProfileBasic, ProfileImproved}
+
+// defaultLLMModels holds the per-corrector models for the basic and improved
+// profiles; correctors not listed use their own configured model.
+var defaultLLMModels = map[string][2]string{
+	"mistral": {"mistral-small-latest", "mistral-large-latest"},
+}
+
+// LoadProfile returns the profile for name, falling back to basic. Every
+// field can be overridden with PROFILE_<NAME>_<FIELD> environment variables,
+// e.g. PROFILE_BASIC_LLM_MODEL or PROFILE_IMPROVED_CORRECT=false.
+func LoadProfile(name string) PipelineProfile {
+	if name != ProfileImproved {
+		name = ProfileBasic
+	}
+
+	corrector := os.Getenv("CORRECTOR")
+	if corrector == "" {
+		corrector = "mistral"
+	}
+	llmModels := defaultLLMModels[corrector]
+
+	var p PipelineProfile
+	switch name {
+	case ProfileImproved:
+		p = PipelineProfile{
+			Name:        ProfileImproved,
+			OCRModel:    "handwritten",
+			LLMModel:    llmModels[1],
+			Temperature: 0.2,
+			Correct:     true,
+			OCRTimeout:  60 * time.Second,
+			LLMTimeout:  90 * time.Second,
+		}
+		// MISTRAL_MODEL predates profiles and keeps pointing at the accurate one.
+		if m := os.Getenv("MISTRAL_MODEL"); m != "" && corrector == "mistral" {
+			p.LLMModel = m
+		}
+	default:
+		p = PipelineProfile{
+			Name:        ProfileBasic,
+			OCRModel:    "handwritten",
+			LLMModel:    llmModels[0],
+			Temperature: 0.3,
+			Correct:     true,
+			OCRTimeout:  30 * time.Second,
+			LLMTimeout:  30 * time.Second,
+		}
+	}
+
+	prefix := "PROFILE_" + map[string]string{ProfileBasic: "BASIC", ProfileImproved: "IMPROVED"}[name] + "_"
+	if v := os.Getenv(prefix + "OCR_MODEL"); v != "" {
+		p.OCRModel = v
+	}
+	if v := os.Getenv(prefix + "LLM_MODEL"); v != "" {
+		p.LLMModel = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv(prefix+"TEMPERATURE"), 64); err == nil {
+		p.Temperature = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv(prefix + "CORRECT")); err == nil {
+		p.Correct = v
+	}
+	if v, err := time.ParseDuration(os.Getenv(prefix + "OCR_TIMEOUT")); err == nil {
+		p.OCRTimeout = v
+	}
+	if v, err := time.ParseDuration(os.Getenv(prefix + "LLM_TIMEOUT")); err == nil {
+		p.LLMTimeout = v
+	}
+	return p
+}
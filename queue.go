@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when the queue holds its maximum number of jobs.
+	ErrQueueFull = errors.New("recognition queue is full")
+	// ErrUserQueueLimit is returned when a chat already has too many jobs.
+	ErrUserQueueLimit = errors.New("too many recognition jobs for this chat")
+)
+
+type job struct {
+	chatID int64
+	run    func()
+}
+
+// JobQueue runs recognition jobs on a fixed number of workers so a burst of
+// photos cannot exceed the OCR and LLM quotas.
+type JobQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []*job
+	perChat   map[int64]int // queued and running jobs per chat
+	idle      int           // workers waiting for a job
+	capacity  int
+	userLimit int
+}
+
+// NewJobQueue starts workers that take jobs from a queue of at most capacity
+// entries, allowing userLimit queued or running jobs per chat.
+func NewJobQueue(workers, capacity, userLimit int) *JobQueue {
+	q := &JobQueue{
+		perChat:   make(map[int64]int),
+		capacity:  capacity,
+		userLimit: userLimit,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// NewJobQueueFromEnv configures the queue with WORKERS, QUEUE_SIZE and
+// QUEUE_PER_USER.
+func NewJobQueueFromEnv() *JobQueue {
+	return NewJobQueue(
+		envInt("WORKERS", 2),
+		envInt("QUEUE_SIZE", 20),
+		envInt("QUEUE_PER_USER", 3),
+	)
+}
+
+// Submit enqueues run for chatID. It returns the job's place in line, or 0
+// when an idle worker picks it up right away.
+func (q *JobQueue) Submit(chatID int64, run func()) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= q.capacity {
+		return 0, ErrQueueFull
+	}
+	if q.perChat[chatID] >= q.userLimit {
+		return 0, ErrUserQueueLimit
+	}
+	q.pending = append(q.pending, &job{chatID: chatID, run: run})
+	q.perChat[chatID]++
+	q.cond.Signal()
+
+	position := len(q.pending) - q.idle
+	if position < 0 {
+		position = 0
+	}
+	return position, nil
+}
+
+func (q *JobQueue) worker() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.idle++
+			q.cond.Wait()
+			q.idle--
+		}
+		j := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		q.runJob(j)
+
+		q.mu.Lock()
+		if q.perChat[j.chatID]--; q.perChat[j.chatID] <= 0 {
+			delete(q.perChat, j.chatID)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// runJob keeps a panicking job from taking its worker down with it.
+func (q *JobQueue) runJob(j *job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("job for chat %d panicked: %v", j.chatID, r)
+		}
+	}()
+	j.run()
+}
+
+// envInt reads a positive integer from the environment.
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
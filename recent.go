@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// recentResultsSize is how many transcripts stay available for the
+// "show changes" button; older ones are forgotten.
+const recentResultsSize = 500
+
+// recentPage keeps the texts of one recognized page needed to show a diff.
+type recentPage struct {
+	OCRText string
+	Text    string
+	Failed  bool
+}
+
+type recentEntry struct {
+	chatID int64
+	pages  []recentPage
+}
+
+// RecentResults is a bounded in-memory cache of the latest transcripts,
+// keyed by short IDs that fit into inline button callback data.
+type RecentResults struct {
+	mu      sync.Mutex
+	size    int
+	next    uint64
+	entries map[string]recentEntry
+	order   []string
+}
+
+// NewRecentResults returns a cache holding at most size transcripts.
+func NewRecentResults(size int) *RecentResults {
+	return &RecentResults{size: size, entries: make(map[string]recentEntry)}
+}
+
+// Put stores the pages of a transcript and returns its ID.
+func (c *RecentResults) Put(chatID int64, results []*Result, errs []error) string {
+	pages := make([]recentPage, len(results))
+	for i, result := range results {
+		pages[i] = recentPage{OCRText: result.OCRText, Text: result.Text, Failed: errs[i] != nil}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next++
+	id := strconv.FormatUint(c.next, 36)
+	c.entries[id] = recentEntry{chatID: chatID, pages: pages}
+	c.order = append(c.order, id)
+	if len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return id
+}
+
+// Get returns the pages stored under id if they belong to the chat.
+func (c *RecentResults) Get(chatID int64, id string) ([]recentPage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || entry.chatID != chatID {
+		return nil, false
+	}
+	return entry.pages, true
+}
+
+// changed reports whether the corrector altered any page.
+func changed(results []*Result, errs []error) bool {
+	for i, result := range results {
+		if errs[i] == nil && result.OCRText != result.Text {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// telegramMessageLimit is the longest message Telegram accepts, counted
+	// in UTF-16 code units.
+	telegramMessageLimit = 4096
+	// messageReserve is left free in every part for the part number and the
+	// tags that close and reopen formatting across parts.
+	messageReserve = 96
+)
+
+// maxMessageText is the longest text sent as messages; a longer one goes as
+// a TXT document. MAX_MESSAGE_TEXT overrides it.
+func maxMessageText() int {
+	return envInt("MAX_MESSAGE_TEXT", 3*telegramMessageLimit)
+}
+
+var htmlTagPattern = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// sendText sends a text reply of any length. Text over the Telegram limit is
+// split on paragraph, line or word boundaries into numbered parts, with
+// HTML formatting closed at the end of a part and reopened in the next one;
+// text over maxMessageText is sent as a TXT document instead. The markup
+// goes with the last message. parseMode is "" or tgbotapi.ModeHTML.
+func sendText(bot *tgbotapi.BotAPI, chatID int64, text, parseMode string, markup interface{}) {
+	if utf16Len(text) > maxMessageText() {
+		if parseMode == tgbotapi.ModeHTML {
+			text = stripHTML(text)
+		}
+		file := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+			Name:  "result.txt",
+			Bytes: []byte(text),
+		})
+		file.ReplyMarkup = markup
+		send(bot, chatID, file)
+		return
+	}
+
+	parts := splitMessage(text, telegramMessageLimit-messageReserve, parseMode == tgbotapi.ModeHTML)
+	for i, part := range parts {
+		if len(parts) > 1 {
+			part = fmt.Sprintf("(%d/%d)\n", i+1, len(parts)) + part
+		}
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = parseMode
+		if i == len(parts)-1 {
+			msg.ReplyMarkup = markup
+		}
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("send part %d/%d to %d: %v", i+1, len(parts), chatID, err)
+			if parseMode == "" {
+				continue
+			}
+			// Telegram не разобрал разметку: отправляем эту часть без неё
+			msg.Text = stripHTML(part)
+			msg.ParseMode = ""
+			send(bot, chatID, msg)
+		}
+	}
+}
+
+// send sends a message and logs a failure.
+func send(bot *tgbotapi.BotAPI, chatID int64, c tgbotapi.Chattable) {
+	if _, err := bot.Send(c); err != nil {
+		log.Printf("send to %d: %v", chatID, err)
+	}
+}
+
+// splitMessage splits text into parts of at most limit UTF-16 units,
+// preferring to cut between paragraphs, then lines, then words. In HTML
+// mode cuts never fall inside a tag or an entity and every part is
+// balanced: tags open at a cut are closed before it and reopened after.
+// The added tags, like a closing tag kept before a cut, may exceed limit.
+func splitMessage(text string, limit int, isHTML bool) []string {
+	var parts []string
+	for utf16Len(text) > limit {
+		cut := cutPoint(text, limit, isHTML)
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	if len(parts) > 0 {
+		text = strings.TrimRight(text, " \n")
+	}
+	if text != "" || len(parts) == 0 {
+		parts = append(parts, text)
+	}
+	if isHTML {
+		parts = balanceHTML(parts)
+	}
+	return parts
+}
+
+// cutPoint returns the byte offset to cut text at so that the head fits
+// into limit UTF-16 units.
+func cutPoint(text string, limit int, isHTML bool) int {
+	end, units := 0, 0
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if units+utf16.RuneLen(r) > limit {
+			break
+		}
+		units += utf16.RuneLen(r)
+		end += size
+	}
+	head := text[:end]
+
+	// Абзац или строку берём, только если часть выйдет не слишком короткой
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		shortest := end / 2
+		if sep == " " {
+			shortest = 1
+		}
+		for i := strings.LastIndex(head, sep); i >= shortest; i = strings.LastIndex(head[:i], sep) {
+			if !isHTML || !insideTag(head[:i]) {
+				return i
+			}
+		}
+	}
+
+	if isHTML {
+		if insideTag(head) {
+			end = strings.LastIndex(head, "<")
+			// Закрывающий тег оставляем в этой части, иначе следующая выйдет пустой;
+			// текста он не добавляет, а место на теги есть в messageReserve
+			if gt := strings.Index(text[end:], ">"); strings.HasPrefix(text[end:], "</") && gt >= 0 {
+				end += gt + 1
+			}
+		} else if amp := strings.LastIndex(head, "&"); amp >= 0 && !strings.Contains(head[amp:], ";") {
+			end = amp
+		}
+	}
+	if end == 0 {
+		// Тег длиннее лимита — режем как есть, чтобы не зациклиться
+		_, size := utf8.DecodeRuneInString(text)
+		end = size
+	}
+	return end
+}
+
+// insideTag reports whether the HTML text ends inside an unclosed tag.
+func insideTag(text string) bool {
+	return strings.LastIndex(text, "<") > strings.LastIndex(text, ">")
+}
+
+// balanceHTML closes the tags left open at the end of each part and
+// reopens them at the start of the next one.
+func balanceHTML(parts []string) []string {
+	type openTag struct{ name, tag string }
+	var open []openTag
+	balanced := make([]string, len(parts))
+	for i, part := range parts {
+		var prefix strings.Builder
+		for _, t := range open {
+			prefix.WriteString(t.tag)
+		}
+		for _, m := range htmlTagPattern.FindAllStringSubmatch(part, -1) {
+			name := strings.ToLower(m[2])
+			if m[1] == "" {
+				open = append(open, openTag{name, m[0]})
+				continue
+			}
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j].name == name {
+					open = open[:j]
+					break
+				}
+			}
+		}
+		var suffix strings.Builder
+		for j := len(open) - 1; j >= 0; j-- {
+			suffix.WriteString("</" + open[j].name + ">")
+		}
+		balanced[i] = prefix.String() + part + suffix.String()
+	}
+	return balanced
+}
+
+// stripHTML turns Telegram HTML into plain text.
+func stripHTML(text string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		limit  int
+		isHTML bool
+		want   []string
+	}{
+		{"exactly at the limit", strings.Repeat("a", 10), 10, false, []string{strings.Repeat("a", 10)}},
+		{"one over the limit", "aaaaa bbbbb", 10, false, []string{"aaaaa", "bbbbb"}},
+		{"paragraph before line", "aaaa\nbb\n\ncc dd", 10, false, []string{"aaaa\nbb", "cc dd"}},
+		{"long word", "aaaaaaaaaa", 4, false, []string{"aaaa", "aaaa", "aa"}},
+		{"surrogate pairs", "😀😀😀", 4, false, []string{"😀😀", "😀"}},
+		{"surrogate pair not split", "😀😀😀", 3, false, []string{"😀", "😀", "😀"}},
+		{"bold closed and reopened", "<b>one two</b>", 8, true, []string{"<b>one</b>", "<b>two</b>"}},
+		{"link closed and reopened", `<a href="http://x">link text</a>`, 24, true,
+			[]string{`<a href="http://x">link</a>`, `<a href="http://x">text</a>`}},
+		{"cut before a tag", "ab<b>cd</b>", 4, true, []string{"ab", "<b>c</b>", "<b>d</b>"}},
+		{"entity at the boundary", "ab&amp;cd", 6, true, []string{"ab", "&amp;c", "d"}},
+		{"entity in plain text", "ab&amp;cd", 6, false, []string{"ab&amp", ";cd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit, tt.isHTML)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			if tt.isHTML {
+				return
+			}
+			for _, part := range got {
+				if n := utf16Len(part); n > tt.limit {
+					t.Errorf("part %q has %d UTF-16 units, limit %d", part, n, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestUTF16Len(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"abc", 3},
+		{"привет", 6},
+		{"😀", 2},
+		{"a😀b", 4},
+	}
+	for _, tt := range tests {
+		if got := utf16Len(tt.text); got != tt.want {
+			t.Errorf("utf16Len(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	got := stripHTML(`<b>a &amp; b</b> <a href="http://x">link</a>`)
+	if want := "a & b link"; got != want {
+		t.Errorf("stripHTML = %q, want %q", got, want)
+	}
+}
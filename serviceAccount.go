@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ServiceAccountKey is an authorized key file as produced by
+// `yc iam key create --output key.json`.
+type ServiceAccountKey struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+}
+
+// JWTTokenRequest exchanges a signed JWT for an IAM token.
+type JWTTokenRequest struct {
+	JWT string `json:"jwt"`
+}
+
+// LoadServiceAccountKey reads an authorized key JSON file.
+func LoadServiceAccountKey(path string) (*ServiceAccountKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %v", err)
+	}
+	var key ServiceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("unmarshal key file: %v", err)
+	}
+	if key.ID == "" || key.ServiceAccountID == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("key file %s lacks id, service_account_id or private_key", path)
+	}
+	return &key, nil
+}
+
+// rsaKey parses the PEM private key. Yandex prepends a comment line to the
+// PEM block, which pem.Decode skips.
+func (k *ServiceAccountKey) rsaKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in private_key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if rsaKey, err1 := x509.ParsePKCS1PrivateKey(block.Bytes); err1 == nil {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("parse private key: %v", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// SignedJWT builds a PS256 JWT for audience, valid for one hour from now.
+func (k *ServiceAccountKey) SignedJWT(audience string, now time.Time) (string, error) {
+	privateKey, err := k.rsaKey()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"typ": "JWT",
+		"alg": "PS256",
+		"kid": k.ID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal JWT header: %v", err)
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": k.ServiceAccountID,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal JWT claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, digest[:],
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return "", fmt.Errorf("sign JWT: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ServiceAccountTokenFetcher exchanges JWTs signed with key for IAM tokens.
+func ServiceAccountTokenFetcher(key *ServiceAccountKey) func() (TokenResponse, error) {
+	return func() (TokenResponse, error) {
+		url := iamURL()
+		jwt, err := key.SignedJWT(url, time.Now())
+		if err != nil {
+			return TokenResponse{}, err
+		}
+		return exchangeIAMToken(url, JWTTokenRequest{JWT: jwt})
+	}
+}
+
+// NewIAMTokenSource builds the token source selected with YANDEX_AUTH:
+// "oauth" (YANDEX_OAUTH), "service_account" (YANDEX_SA_KEY_FILE) or
+// "static" (IAM_TOKEN). Without YANDEX_AUTH the first configured source in
+// that order wins. It returns nil when no credentials are set.
+func NewIAMTokenSource() (TokenSource, error) {
+	mode := os.Getenv("YANDEX_AUTH")
+	if mode == "" {
+		switch {
+		case os.Getenv("YANDEX_OAUTH") != "":
+			mode = "oauth"
+		case os.Getenv("YANDEX_SA_KEY_FILE") != "":
+			mode = "service_account"
+		case os.Getenv("IAM_TOKEN") != "":
+			mode = "static"
+		default:
+			return nil, nil
+		}
+	}
+
+	switch mode {
+	case "oauth":
+		oauth := os.Getenv("YANDEX_OAUTH")
+		if oauth == "" {
+			return nil, fmt.Errorf("YANDEX_AUTH=oauth requires YANDEX_OAUTH")
+		}
+		return NewIAMTokenProvider(OAuthTokenFetcher(oauth)), nil
+	case "service_account":
+		path := os.Getenv("YANDEX_SA_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("YANDEX_AUTH=service_account requires YANDEX_SA_KEY_FILE")
+		}
+		key, err := LoadServiceAccountKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := key.rsaKey(); err != nil {
+			return nil, fmt.Errorf("service account key %s: %v", path, err)
+		}
+		return NewIAMTokenProvider(ServiceAccountTokenFetcher(key)), nil
+	case "static":
+		token := os.Getenv("IAM_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("YANDEX_AUTH=static requires IAM_TOKEN")
+		}
+		return staticToken(token), nil
+	}
+	return nil, fmt.Errorf("unknown YANDEX_AUTH %q", mode)
+}
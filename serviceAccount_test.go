@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeServiceAccountKey saves a key file the way `yc iam key create` does,
+// with a comment line before the PEM block.
+func writeServiceAccountKey(t *testing.T, privateKey *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(ServiceAccountKey{
+		ID:               "key-id",
+		ServiceAccountID: "sa-id",
+		PrivateKey: "PLEASE DO NOT REMOVE THIS LINE! Yandex.Cloud SA Key ID <key-id>\n" +
+			string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkJWT verifies the PS256 signature and returns the header and claims.
+func checkJWT(jwt string, publicKey *rsa.PublicKey) (header, claims map[string]interface{}, err error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("JWT must have three parts")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPSS(publicKey, crypto.SHA256, digest[:], signature,
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+		return nil, nil, err
+	}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, claims, nil
+}
+
+func TestServiceAccountTokenExchange(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+
+	var calls atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req JWTTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		header, claims, err := checkJWT(req.JWT, &privateKey.PublicKey)
+		if err != nil {
+			t.Errorf("JWT: %v", err)
+			http.Error(w, "bad JWT", http.StatusBadRequest)
+			return
+		}
+		if header["alg"] != "PS256" || header["kid"] != "key-id" {
+			t.Errorf("header = %v", header)
+		}
+		if claims["iss"] != "sa-id" || claims["aud"] != server.URL {
+			t.Errorf("claims = %v, want iss sa-id and aud %s", claims, server.URL)
+		}
+		json.NewEncoder(w).Encode(TokenResponse{IamToken: "iam-token", ExpiresAt: expiresAt.Format(time.RFC3339Nano)})
+	}))
+	defer server.Close()
+
+	t.Setenv("YANDEX_AUTH", "service_account")
+	t.Setenv("YANDEX_SA_KEY_FILE", writeServiceAccountKey(t, privateKey))
+	t.Setenv("YANDEX_IAM_URL", server.URL)
+
+	source, err := NewIAMTokenSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, ok := source.(*IAMTokenProvider)
+	if !ok {
+		t.Fatalf("source is %T, want *IAMTokenProvider", source)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := provider.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "iam-token" {
+			t.Fatalf("token = %q", token)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("IAM endpoint called %d times, want 1 (token not cached)", n)
+	}
+	if h := provider.Health(); !h.Healthy || !h.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("health = %+v, want expiry %s", h, expiresAt)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SessionManager runs work for the same chat strictly in arrival order while
+// different chats proceed in parallel. Each chat with pending work gets one
+// goroutine that drains its queue and exits once the queue is empty.
+type SessionManager struct {
+	mu     sync.Mutex
+	queues map[int64][]func()
+	wg     sync.WaitGroup
+}
+
+// NewSessionManager returns an idle session manager.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{queues: make(map[int64][]func())}
+}
+
+// Dispatch schedules fn after all work previously dispatched for chatID.
+func (m *SessionManager) Dispatch(chatID int64, fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if queue, busy := m.queues[chatID]; busy {
+		m.queues[chatID] = append(queue, fn)
+		return
+	}
+	m.queues[chatID] = []func(){}
+	m.wg.Add(1)
+	go m.run(chatID, fn)
+}
+
+// Wait blocks until every dispatched function has returned.
+func (m *SessionManager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *SessionManager) run(chatID int64, fn func()) {
+	defer m.wg.Done()
+	for {
+		fn()
+
+		m.mu.Lock()
+		queue := m.queues[chatID]
+		if len(queue) == 0 {
+			delete(m.queues, chatID)
+			m.mu.Unlock()
+			return
+		}
+		fn = queue[0]
+		m.queues[chatID] = queue[1:]
+		m.mu.Unlock()
+	}
+}
+
+// updateChatID returns the chat an update belongs to.
+func updateChatID(update tgbotapi.Update) (int64, bool) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID, true
+	}
+	return 0, false
+}
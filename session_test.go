@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerKeepsPerChatOrder(t *testing.T) {
+	const chats, jobs = 5, 50
+	m := NewSessionManager()
+
+	var mu sync.Mutex
+	got := make(map[int64][]int)
+	for i := 0; i < jobs; i++ {
+		for chat := int64(1); chat <= chats; chat++ {
+			m.Dispatch(chat, func() {
+				// Разная длительность, чтобы чаты перемешивались
+				time.Sleep(time.Duration((int(chat)*7+i)%3) * 100 * time.Microsecond)
+				mu.Lock()
+				got[chat] = append(got[chat], i)
+				mu.Unlock()
+			})
+		}
+	}
+	m.Wait()
+
+	for chat := int64(1); chat <= chats; chat++ {
+		if len(got[chat]) != jobs {
+			t.Fatalf("chat %d ran %d jobs, want %d", chat, len(got[chat]), jobs)
+		}
+		for i, v := range got[chat] {
+			if v != i {
+				t.Fatalf("chat %d ran job %d at position %d", chat, v, i)
+			}
+		}
+	}
+}
+
+func TestSessionManagerRunsChatsInParallel(t *testing.T) {
+	m := NewSessionManager()
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	m.Dispatch(1, func() { <-release })
+	m.Dispatch(2, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("chat 2 waited for chat 1")
+	}
+	close(release)
+	m.Wait()
+}
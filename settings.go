@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// settingsField describes one setting of the inline settings menu. Values
+// lists every valid choice; callback data carries the value itself, so
+// anything else is rejected.
+type settingsField struct {
+	Key    string // callback data and tr key of the setting title
+	Prompt string // tr key shown above the choices, Key + ":" when empty
+	Done   string // tr key of the confirmation
+	Values func() []string
+	Label  func(lang, value string) string
+	Get    func(s UserSettings) string
+	Set    func(s *UserSettings, value string)
+}
+
+// settingsFields are the settings in menu order.
+var settingsFields = []settingsField{
+	{
+		Key:    "language",
+		Done:   "language_set",
+		Values: func() []string { return Languages },
+		Label:  func(lang, value string) string { return translate(value, "language_name") },
+		Get:    func(s UserSettings) string { return s.Language },
+		Set:    func(s *UserSettings, value string) { s.Language = value },
+	},
+	{
+		Key:  "format",
+		Done: "format_set",
+		Values: func() []string {
+			return []string{"Простой текст", "TXT-файл", "PDF-файл", "PDF-скан", "DOCX-файл",
+				"MD-файл", "HTML-файл", "Структурированный текст",
+				"hOCR", "ALTO XML", "PAGE XML", "JSON"}
+		},
+		Label: formatLabel,
+		Get:   func(s UserSettings) string { return s.Format },
+		Set:   func(s *UserSettings, value string) { s.Format = value },
+	},
+	{
+		Key:    "doc_lang",
+		Done:   "doc_lang_set",
+		Values: func() []string { return docLanguages },
+		Label:  docLanguageLabel,
+		Get:    func(s UserSettings) string { return s.DocLang },
+		Set:    func(s *UserSettings, value string) { s.DocLang = value },
+	},
+	{
+		Key:    "model",
+		Done:   "model_set",
+		Values: func() []string { return ProfileNames },
+		Label:  func(lang, value string) string { return translate(lang, "model_"+value) },
+		Get:    func(s UserSettings) string { return s.Model },
+		Set:    func(s *UserSettings, value string) { s.Model = value },
+	},
+	{
+		Key:    "engine",
+		Done:   "engine_set",
+		Values: OCREngineNames,
+		Label:  func(lang, value string) string { return value },
+		Get:    func(s UserSettings) string { return engineName(s.Engine) },
+		Set:    func(s *UserSettings, value string) { s.Engine = value },
+	},
+	{
+		Key:    "highlight",
+		Prompt: "highlight_prompt",
+		Done:   "highlight_set",
+		Values: func() []string {
+			values := []string{formatThreshold(0)}
+			for _, t := range highlightThresholds {
+				values = append(values, formatThreshold(t))
+			}
+			return values
+		},
+		Label: func(lang, value string) string { return highlightLabel(lang, parseThreshold(value)) },
+		Get:   func(s UserSettings) string { return formatThreshold(s.Highlight) },
+		Set:   func(s *UserSettings, value string) { s.Highlight = parseThreshold(value) },
+	},
+	{
+		Key:    "diff",
+		Done:   "diff_set",
+		Values: func() []string { return []string{"false", "true"} },
+		Label:  func(lang, value string) string { return diffLabel(lang, value == "true") },
+		Get:    func(s UserSettings) string { return strconv.FormatBool(s.ShowDiff) },
+		Set:    func(s *UserSettings, value string) { s.ShowDiff = value == "true" },
+	},
+}
+
+func formatThreshold(t float64) string { return strconv.FormatFloat(t, 'f', -1, 64) }
+
+func parseThreshold(value string) float64 {
+	t, _ := strconv.ParseFloat(value, 64)
+	return t
+}
+
+func settingsFieldByKey(key string) (settingsField, bool) {
+	for _, f := range settingsFields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return settingsField{}, false
+}
+
+func validSetting(f settingsField, value string) bool {
+	for _, v := range f.Values() {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// settingsDialog is the /settings flow: a menu state listing the settings
+// and one state per setting offering its valid values.
+var settingsDialog = newSettingsDialog()
+
+func newSettingsDialog() *Dialog {
+	states := map[string]DialogState{
+		"menu": {
+			Enter: func(c *DialogContext) DialogView { return settingsMenu(c.ChatID) },
+			Handle: func(c *DialogContext, input string) Transition {
+				if input == "close" {
+					return Transition{Next: DialogEnd}
+				}
+				if _, ok := settingsFieldByKey(input); ok {
+					return Transition{Next: input}
+				}
+				return Transition{Notice: tr(c.ChatID, "invalid_choice")}
+			},
+		},
+	}
+	for _, f := range settingsFields {
+		states[f.Key] = DialogState{
+			Enter: func(c *DialogContext) DialogView { return settingsChoices(c.ChatID, f) },
+			Handle: func(c *DialogContext, input string) Transition {
+				switch {
+				case input == "back":
+					return Transition{Next: "menu"}
+				case input == "cancel":
+					return Transition{Next: DialogEnd}
+				case !validSetting(f, input):
+					return Transition{Notice: tr(c.ChatID, "invalid_choice")}
+				}
+				updateSettings(c.ChatID, func(s *UserSettings) { f.Set(s, input) })
+				lang := settingsStore.Get(c.ChatID).Language
+				return Transition{Next: "menu", Notice: tr(c.ChatID, f.Done) + ": " + f.Label(lang, input)}
+			},
+		}
+	}
+
+	return &Dialog{
+		Name:    "settings",
+		Start:   "menu",
+		Timeout: 10 * time.Minute,
+		States:  states,
+		Finish: func(c *DialogContext) DialogView {
+			return DialogView{Text: tr(c.ChatID, "settings_closed")}
+		},
+	}
+}
+
+// settingsMenu renders the current settings with a choice per setting.
+func settingsMenu(chatID int64) DialogView {
+	settings := settingsStore.Get(chatID)
+	lang := settings.Language
+
+	text := tr(chatID, "settings_menu")
+	for i, f := range settingsFields {
+		text += fmt.Sprintf("\n%d. %s: %s", i+1, tr(chatID, f.Key), f.Label(lang, f.Get(settings)))
+	}
+	text += "\n\n" + tr(chatID, "settings_instruction")
+
+	var rows [][]DialogChoice
+	var row []DialogChoice
+	for _, f := range settingsFields {
+		row = append(row, DialogChoice{Label: translate(lang, "change_"+f.Key), Input: f.Key})
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []DialogChoice{{Label: translate(lang, "close"), Input: "close"}})
+	return DialogView{Text: text, Choices: rows}
+}
+
+// settingsChoices renders the valid values of one setting, marking the
+// current one.
+func settingsChoices(chatID int64, f settingsField) DialogView {
+	settings := settingsStore.Get(chatID)
+	lang := settings.Language
+
+	text := tr(chatID, f.Key) + ":"
+	if f.Prompt != "" {
+		text = tr(chatID, f.Prompt)
+	}
+
+	var rows [][]DialogChoice
+	current := f.Get(settings)
+	for _, value := range f.Values() {
+		label := f.Label(lang, value)
+		if value == current {
+			label = "✓ " + label
+		}
+		rows = append(rows, []DialogChoice{{Label: label, Input: value}})
+	}
+	rows = append(rows, []DialogChoice{
+		{Label: translate(lang, "back"), Input: "back"},
+		{Label: translate(lang, "cancel"), Input: "cancel"},
+	})
+	return DialogView{Text: text, Choices: rows}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// settingsSchemaVersion is the current on-disk format of the settings file.
+// Bump it together with a new entry in settingsMigrations.
+const settingsSchemaVersion = 3
+
+// settingsMigrations upgrade raw user records one version at a time:
+// settingsMigrations[i] turns version i+1 into version i+2. Fields added to
+// UserSettings without a migration simply take their DefaultSettings value.
+var settingsMigrations = []func(user map[string]interface{}){
+	migrateLanguageCodes,
+	migrateDocLanguages,
+}
+
+// migrateLanguageCodes replaces the language names of version 1 with the
+// locale codes used since version 2.
+func migrateLanguageCodes(user map[string]interface{}) {
+	name, _ := user["language"].(string)
+	switch name {
+	case "Английский":
+		user["language"] = "en"
+	case "Русский":
+		user["language"] = "ru"
+	default:
+		if !isLanguage(name) {
+			user["language"] = defaultLanguage
+		}
+	}
+}
+
+// migrateDocLanguages resets document languages that are no longer offered
+// (uk, kk and de before version 3) to auto detection.
+func migrateDocLanguages(user map[string]interface{}) {
+	docLang, ok := user["doc_lang"].(string)
+	if ok && !slices.Contains(docLanguages, docLang) {
+		user["doc_lang"] = "auto"
+	}
+}
+
+// SettingsStore keeps per-chat user settings.
+type SettingsStore interface {
+	// Get returns a copy of the chat's settings, or the defaults.
+	Get(chatID int64) UserSettings
+	// Update applies fn to the chat's settings and persists the result.
+	Update(chatID int64, fn func(s *UserSettings)) (UserSettings, error)
+}
+
+// MemorySettingsStore keeps settings in memory only.
+type MemorySettingsStore struct {
+	mu    sync.Mutex
+	users map[int64]*UserSettings
+}
+
+// NewMemorySettingsStore returns an empty in-memory store.
+func NewMemorySettingsStore() *MemorySettingsStore {
+	return &MemorySettingsStore{users: make(map[int64]*UserSettings)}
+}
+
+// Get implements SettingsStore.
+func (m *MemorySettingsStore) Get(chatID int64) UserSettings {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.users[chatID]; ok {
+		return *s
+	}
+	return *DefaultSettings()
+}
+
+// Update implements SettingsStore.
+func (m *MemorySettingsStore) Update(chatID int64, fn func(s *UserSettings)) (UserSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.users[chatID]
+	if !ok {
+		s = DefaultSettings()
+		m.users[chatID] = s
+	}
+	fn(s)
+	return *s, nil
+}
+
+// settingsFile is the JSON layout of FileSettingsStore.
+type settingsFile struct {
+	Version int                               `json:"version"`
+	Users   map[string]map[string]interface{} `json:"users"`
+}
+
+// FileSettingsStore is a MemorySettingsStore that is loaded from and written
+// back to a JSON file on every change.
+type FileSettingsStore struct {
+	MemorySettingsStore
+	path string
+}
+
+// NewFileSettingsStore loads settings from path, migrating older schema
+// versions. A missing file yields an empty store.
+func NewFileSettingsStore(path string) (*FileSettingsStore, error) {
+	store := &FileSettingsStore{
+		MemorySettingsStore: MemorySettingsStore{users: make(map[int64]*UserSettings)},
+		path:                path,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read settings: %v", err)
+	}
+
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unmarshal settings: %v", err)
+	}
+	if file.Version > settingsSchemaVersion {
+		return nil, fmt.Errorf("settings file version %d is newer than supported %d", file.Version, settingsSchemaVersion)
+	}
+	if file.Version < 1 {
+		file.Version = 1
+	}
+
+	for key, raw := range file.Users {
+		chatID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Printf("settings: skipping invalid chat id %q", key)
+			continue
+		}
+		for v := file.Version; v < settingsSchemaVersion; v++ {
+			settingsMigrations[v-1](raw)
+		}
+		migrated, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("migrate settings for %d: %v", chatID, err)
+		}
+		s := DefaultSettings()
+		if err := json.Unmarshal(migrated, s); err != nil {
+			return nil, fmt.Errorf("decode settings for %d: %v", chatID, err)
+		}
+		store.users[chatID] = s
+	}
+
+	if file.Version != settingsSchemaVersion {
+		store.mu.Lock()
+		err := store.save()
+		store.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Update implements SettingsStore.
+func (f *FileSettingsStore) Update(chatID int64, fn func(s *UserSettings)) (UserSettings, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.users[chatID]
+	if !ok {
+		s = DefaultSettings()
+		f.users[chatID] = s
+	}
+	fn(s)
+	return *s, f.save()
+}
+
+// save writes all users to disk atomically. The caller must hold f.mu.
+func (f *FileSettingsStore) save() error {
+	file := struct {
+		Version int                      `json:"version"`
+		Users   map[string]*UserSettings `json:"users"`
+	}{
+		Version: settingsSchemaVersion,
+		Users:   make(map[string]*UserSettings, len(f.users)),
+	}
+	for chatID, s := range f.users {
+		file.Users[strconv.FormatInt(chatID, 10)] = s
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal settings: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create settings file: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write settings: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write settings: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("replace settings: %v", err)
+	}
+	return nil
+}
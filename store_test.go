@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSettingsStoreMigratesDocLanguages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	old := `{"version": 2, "users": {"1": {"language": "ru", "doc_lang": "uk"}, "2": {"language": "en", "doc_lang": "ru,en"}}}`
+	if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileSettingsStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Get(1).DocLang; got != "auto" {
+		t.Errorf("doc_lang uk migrated to %q, want auto", got)
+	}
+	if got := store.Get(2).DocLang; got != "ru,en" {
+		t.Errorf("doc_lang ru,en changed to %q", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct{ Version int }
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Version != settingsSchemaVersion {
+		t.Errorf("saved version = %d, want %d", file.Version, settingsSchemaVersion)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ElementKind is the role of a reconstructed document element.
+type ElementKind int
+
+const (
+	ElementParagraph ElementKind = iota
+	ElementHeading
+	ElementBullet   // Item of a bulleted list
+	ElementNumbered // Item of a numbered list
+)
+
+// Element is a heading, paragraph or list item reconstructed from the text.
+type Element struct {
+	Kind   ElementKind
+	Number string // Marker of a numbered item, e.g. "2" or "б"
+	Text   string
+}
+
+var (
+	bulletPattern   = regexp.MustCompile(`^\s*[-–—•*·]\s+(.+)$`)
+	numberedPattern = regexp.MustCompile(`^\s*(\d{1,3}|[a-zа-яё])[.)]\s+(.+)$`)
+)
+
+const (
+	// headingMaxLength is the longest line still taken for a heading.
+	headingMaxLength = 60
+	// headingHeightRatio is how much taller than the median line a heading
+	// has to be written.
+	headingHeightRatio = 1.3
+)
+
+// documentStructure splits the result text into headings, list items and
+// paragraphs. Blocks and line heights come from the OCR geometry when the
+// corrected text kept the OCR lines; list items are recognized by their
+// markers and headings by size or, without geometry, by capitals.
+func documentStructure(result *Result) []Element {
+	lines := strings.Split(result.Text, "\n")
+	ends := paragraphEnds(result)
+	heights := lineHeights(result, len(lines))
+	median := medianHeight(heights)
+
+	var elements []Element
+	var block []int
+	flush := func() {
+		elements = append(elements, blockElements(lines, block, heights, median)...)
+		block = nil
+	}
+	for i := range lines {
+		block = append(block, i)
+		if ends[i] {
+			flush()
+		}
+	}
+	if len(block) > 0 {
+		flush()
+	}
+	return elements
+}
+
+// blockElements turns the lines of one block into elements.
+func blockElements(lines []string, block []int, heights []float64, median float64) []Element {
+	var elements []Element
+	var current *Element
+	for _, i := range block {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			current = nil
+			continue
+		}
+		if m := bulletPattern.FindStringSubmatch(line); m != nil {
+			elements = append(elements, Element{Kind: ElementBullet, Text: m[1]})
+			current = &elements[len(elements)-1]
+			continue
+		}
+		if m := numberedPattern.FindStringSubmatch(line); m != nil {
+			elements = append(elements, Element{Kind: ElementNumbered, Number: m[1], Text: m[2]})
+			current = &elements[len(elements)-1]
+			continue
+		}
+		if current == nil {
+			kind := ElementParagraph
+			if len(block) == 1 && isHeading(line, heights[i], median) {
+				kind = ElementHeading
+			}
+			elements = append(elements, Element{Kind: kind, Text: line})
+			current = &elements[len(elements)-1]
+			continue
+		}
+		current.Text = joinLine(current.Text, line)
+	}
+	return elements
+}
+
+// joinLine appends a wrapped line, undoing hyphenation at the line end.
+func joinLine(text, line string) string {
+	if strings.HasSuffix(text, "-") {
+		if r := []rune(line); len(r) > 0 && unicode.IsLower(r[0]) {
+			return strings.TrimSuffix(text, "-") + line
+		}
+	}
+	return text + " " + line
+}
+
+// isHeading decides whether a standalone line is a heading: a short line
+// without final punctuation that is written notably larger than the rest
+// or, when sizes are unknown, entirely in capitals.
+func isHeading(line string, height, median float64) bool {
+	if len([]rune(line)) > headingMaxLength || strings.ContainsAny(line[len(line)-1:], ".,;") {
+		return false
+	}
+	if height > 0 && median > 0 {
+		return height >= median*headingHeightRatio
+	}
+	hasLetter := false
+	for _, r := range line {
+		if unicode.IsLower(r) {
+			return false
+		}
+		hasLetter = hasLetter || unicode.IsLetter(r)
+	}
+	return hasLetter && len([]rune(line)) > 1
+}
+
+// lineHeights returns the OCR height of every text line, or zeros when the
+// corrected text no longer matches the OCR lines.
+func lineHeights(result *Result, count int) []float64 {
+	heights := make([]float64, count)
+	if result.OCR == nil {
+		return heights
+	}
+	var ocr []float64
+	for _, block := range result.OCR.Blocks {
+		for _, line := range block.Lines {
+			ocr = append(ocr, float64(line.Box.Height))
+		}
+	}
+	if len(ocr) == count {
+		copy(heights, ocr)
+	}
+	return heights
+}
+
+func medianHeight(heights []float64) float64 {
+	var known []float64
+	for _, h := range heights {
+		if h > 0 {
+			known = append(known, h)
+		}
+	}
+	if len(known) == 0 {
+		return 0
+	}
+	sort.Float64s(known)
+	return known[len(known)/2]
+}
+
+// markdownEscaper escapes characters with a meaning in Markdown text.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+
+// renderMarkdown renders the results as a Markdown document.
+func renderMarkdown(chatID int64, results []*Result, errs []error) string {
+	var parts []string
+	for i, result := range results {
+		if len(results) > 1 {
+			parts = append(parts, "# "+markdownEscaper.Replace(pageHeader(chatID, i+1, len(results))))
+		}
+		if errs[i] != nil {
+			parts = append(parts, markdownEscaper.Replace(fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[i])))
+			continue
+		}
+		elements := documentStructure(result)
+		for j, e := range elements {
+			// В Markdown списки только с цифрами: пункты «б)» остаются абзацами со своим маркером
+			if e.Kind == ElementNumbered && !numericMarker(e.Number) {
+				elements[j] = Element{Kind: ElementParagraph, Text: e.Number + ") " + e.Text}
+			}
+		}
+		parts = append(parts, joinElements(elements, func(e Element) string {
+			text := markdownEscaper.Replace(e.Text)
+			switch e.Kind {
+			case ElementHeading:
+				return "## " + text
+			case ElementBullet:
+				return "- " + text
+			case ElementNumbered:
+				return e.Number + ". " + text
+			}
+			// Абзац, начинающийся с символа разметки, не должен стать заголовком или цитатой
+			if strings.ContainsAny(text[:1], "#>+-=") {
+				text = `\` + text
+			}
+			return text
+		})...)
+	}
+	return strings.Join(parts, "\n\n") + "\n"
+}
+
+// renderHTML renders the results as a standalone HTML page.
+func renderHTML(chatID int64, results []*Result, errs []error) string {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	sb.WriteString("<title>" + html.EscapeString(tr(chatID, "docx_title")) + "</title>\n</head>\n<body>\n")
+	for i, result := range results {
+		if len(results) > 1 {
+			sb.WriteString("<h1>" + html.EscapeString(pageHeader(chatID, i+1, len(results))) + "</h1>\n")
+		}
+		if errs[i] != nil {
+			sb.WriteString("<p>" + html.EscapeString(fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[i])) + "</p>\n")
+			continue
+		}
+		list, listAttrs := "", ""
+		for _, e := range documentStructure(result) {
+			tag, attrs := htmlList(e)
+			if tag != list || attrs != listAttrs {
+				if list != "" {
+					sb.WriteString("</" + list + ">\n")
+				}
+				if tag != "" {
+					sb.WriteString("<" + tag + attrs + ">\n")
+				}
+				list, listAttrs = tag, attrs
+			}
+			text := html.EscapeString(e.Text)
+			switch e.Kind {
+			case ElementHeading:
+				sb.WriteString("<h2>" + text + "</h2>\n")
+			case ElementBullet:
+				sb.WriteString("<li>" + text + "</li>\n")
+			case ElementNumbered:
+				// Номер из оригинала, чтобы «3.» не превратилось в «1.»
+				sb.WriteString(fmt.Sprintf("<li value=\"%d\">%s</li>\n", markerValue(e.Number), text))
+			default:
+				sb.WriteString("<p>" + text + "</p>\n")
+			}
+		}
+		if list != "" {
+			sb.WriteString("</" + list + ">\n")
+		}
+	}
+	sb.WriteString("</body>\n</html>\n")
+	return sb.String()
+}
+
+// renderTelegramHTML renders the results for Telegram's HTML parse mode,
+// which has no headings or lists: headings are bold and list items keep
+// their markers.
+func renderTelegramHTML(chatID int64, results []*Result, errs []error) string {
+	var parts []string
+	for i, result := range results {
+		if len(results) > 1 {
+			parts = append(parts, "<b>"+html.EscapeString(pageHeader(chatID, i+1, len(results)))+"</b>")
+		}
+		if errs[i] != nil {
+			parts = append(parts, html.EscapeString(fmt.Sprintf("%s: %v", tr(chatID, "error_ocr"), errs[i])))
+			continue
+		}
+		parts = append(parts, joinElements(documentStructure(result), func(e Element) string {
+			text := html.EscapeString(e.Text)
+			switch e.Kind {
+			case ElementHeading:
+				return "<b>" + text + "</b>"
+			case ElementBullet:
+				return "• " + text
+			case ElementNumbered:
+				return e.Number + ". " + text
+			}
+			return text
+		})...)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// joinElements renders every element as a paragraph, except that items of
+// the same list share one paragraph, one item per line.
+func joinElements(elements []Element, render func(Element) string) []string {
+	var parts []string
+	for i, e := range elements {
+		text := render(e)
+		if i > 0 && listItem(e.Kind) && sameList(elements[i-1], e) {
+			parts[len(parts)-1] += "\n" + text
+			continue
+		}
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+func listItem(kind ElementKind) bool {
+	return kind == ElementBullet || kind == ElementNumbered
+}
+
+// sameList reports whether b continues the list of a: items of the same
+// kind whose markers are both numbers or both letters.
+func sameList(a, b Element) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	return a.Kind != ElementNumbered || numericMarker(a.Number) == numericMarker(b.Number)
+}
+
+// numericMarker reports whether a numbered item is marked with a number
+// rather than a letter.
+func numericMarker(number string) bool {
+	return number != "" && number[0] >= '0' && number[0] <= '9'
+}
+
+// cyrillicLetters orders the Cyrillic list markers.
+const cyrillicLetters = "абвгдеёжзийклмнопрстуфхцчшщъыьэюя"
+
+// markerValue is the ordinal of a list marker: the number itself, or the
+// position of the letter in its alphabet.
+func markerValue(number string) int {
+	if numericMarker(number) {
+		n, _ := strconv.Atoi(number)
+		return n
+	}
+	r := []rune(number)[0]
+	if r >= 'a' && r <= 'z' {
+		return int(r-'a') + 1
+	}
+	return slices.Index([]rune(cyrillicLetters), r) + 1
+}
+
+// htmlList returns the tag and attributes of the list the element belongs
+// to, or "" for elements outside lists. Numbers and letters make separate
+// lists.
+func htmlList(e Element) (tag, attrs string) {
+	switch {
+	case e.Kind == ElementBullet:
+		return "ul", ""
+	case e.Kind != ElementNumbered:
+		return "", ""
+	case numericMarker(e.Number):
+		return "ol", ""
+	}
+	return "ol", ` type="a"`
+}
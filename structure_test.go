@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListMarkers(t *testing.T) {
+	results := []*Result{{Text: "3. третий\n4. четвёртый\nб) буква\nв) ещё буква"}}
+	errs := []error{nil}
+
+	page := renderHTML(0, results, errs)
+	want := "<ol>\n<li value=\"3\">третий</li>\n<li value=\"4\">четвёртый</li>\n</ol>\n" +
+		"<ol type=\"a\">\n<li value=\"2\">буква</li>\n<li value=\"3\">ещё буква</li>\n</ol>\n"
+	if !strings.Contains(page, want) {
+		t.Errorf("HTML lists:\n%s\nwant\n%s", page, want)
+	}
+
+	md := renderMarkdown(0, results, errs)
+	want = "3. третий\n4. четвёртый\n\nб) буква\n\nв) ещё буква\n"
+	if md != want {
+		t.Errorf("Markdown = %q, want %q", md, want)
+	}
+}
@@ -0,0 +1,8 @@
+#!/bin/sh
+# Downloads the official schemas the archival export tests validate against.
+# Run once and commit the files; the schema tests fail without them.
+set -e
+cd "$(dirname "$0")"
+curl -fsSLo alto-4-4.xsd https://www.loc.gov/standards/alto/v4/alto-4-4.xsd
+curl -fsSLo xlink.xsd https://www.loc.gov/standards/xlink/xlink.xsd
+curl -fsSLo pagecontent-2019-07-15.xsd https://www.primaresearch.org/schema/PAGE/gts/pagecontent/2019-07-15/pagecontent.xsd